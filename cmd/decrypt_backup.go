@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-manager/internal/shipper"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "decrypt-backup <input> <output>",
+		Short: "Decrypt a backup downloaded from the remote bucket",
+		Args:  cobra.ExactArgs(2),
+		Run: func(_ *cobra.Command, args []string) {
+			wd, err := os.Getwd()
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+			c := config.New(config.NewEnv(wd))
+			if err = c.Init(); err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			key, err := shipper.Key(c)
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+			content, err := os.ReadFile(args[0])
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+			content, err = utils.Decrypt(key, content)
+			if err != nil {
+				panic(fmt.Sprintf("cannot decrypt the backup: %v\n", err))
+			}
+			if err = os.WriteFile(args[1], content, 0600); err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			fmt.Println("Backup decrypted to", args[1])
+		},
+	})
+}
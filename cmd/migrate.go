@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ebadidev/arch-manager/internal/app"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var dryRun bool
+
+	command := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the database to the latest schema version",
+		Run: func(_ *cobra.Command, _ []string) {
+			a, err := app.New()
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+			defer a.Logger.Close()
+
+			results, err := a.Database.Migrate(dryRun)
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			if len(results) == 0 {
+				fmt.Println("Database is up to date, schema version", database.LatestSchemaVersion())
+				return
+			}
+			fmt.Print(database.FormatMigrationResults(results))
+			if dryRun {
+				fmt.Println("Dry run, nothing has been written.")
+			}
+		},
+	}
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "print the changes without writing them")
+
+	rootCmd.AddCommand(command)
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ebadidev/arch-manager/internal/app"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var newKey string
+
+	command := &cobra.Command{
+		Use:   "rotate-master-key",
+		Short: "Re-encrypt the database secrets with a new master key",
+		Run: func(_ *cobra.Command, _ []string) {
+			a, err := app.New()
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+			defer a.Close()
+
+			if err = a.Database.Init(); err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			if newKey == "" {
+				if newKey, err = utils.Key32(); err != nil {
+					panic(fmt.Sprintf("%+v\n", err))
+				}
+			}
+			key, err := database.ParseMasterKey(newKey)
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			path := a.Config.Env.MasterKeyPath
+			if database.MasterKeyFromEnv() {
+				if err = a.Database.RotateMasterKey(key); err != nil {
+					panic(fmt.Sprintf("%+v\n", err))
+				}
+				fmt.Printf("Database re-encrypted, set %s to the new key:\n%s\n", database.MasterKeyEnv, newKey)
+			} else {
+				// The new key is kept aside until the database is saved with it, so an interruption loses neither.
+				if err = utils.WriteFileAtomic(path+".new", []byte(newKey), 0600); err != nil {
+					panic(fmt.Sprintf("%+v\n", err))
+				}
+				if err = a.Database.RotateMasterKey(key); err != nil {
+					panic(fmt.Sprintf("%+v\n", err))
+				}
+				retired := fmt.Sprintf("%s.retired-%d", path, time.Now().UnixMilli())
+				if err = os.Rename(path, retired); err != nil && !os.IsNotExist(err) {
+					panic(fmt.Sprintf("%+v\n", err))
+				}
+				if err = os.Rename(path+".new", path); err != nil {
+					panic(fmt.Sprintf("%+v\n", err))
+				}
+				fmt.Println("Database re-encrypted, the new key is in", path)
+				fmt.Println("Older backups need the previous key, kept in", retired)
+			}
+
+			if _, err = a.Database.CreateBackup(); err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+		},
+	}
+	command.Flags().StringVar(&newKey, "key", "", "the new base64-encoded 32-byte master key, generated when empty")
+
+	rootCmd.AddCommand(command)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/ebadidev/arch-manager/internal/http/client"
 	"github.com/ebadidev/arch-manager/internal/http/server"
 	"github.com/ebadidev/arch-manager/internal/licensor"
+	"github.com/ebadidev/arch-manager/internal/shipper"
 	"github.com/ebadidev/arch-manager/internal/writer"
 	"github.com/ebadidev/arch-node/pkg/logger"
 	"github.com/ebadidev/arch-node/pkg/xray"
@@ -34,6 +35,7 @@ type App struct {
 	Xray        *xray.Xray
 	Enigma      *enigma.Enigma
 	Licensor    *licensor.Licensor
+	Shipper     *shipper.Shipper
 }
 
 func New() (a *App, err error) {
@@ -64,7 +66,8 @@ func New() (a *App, err error) {
 	a.Enigma = enigma.New(e.EnigmaKeyPath)
 	a.Licensor = licensor.New(c, a.HttpClient, a.Logger, a.Database, a.Enigma)
 	a.Writer = writer.New(a.Config, a.Database, a.Xray)
-	a.Coordinator = coordinator.New(c, a.Context, a.HttpClient, a.Logger, a.Database, a.Xray, a.Writer)
+	a.Shipper = shipper.New(c, a.Logger, a.Database)
+	a.Coordinator = coordinator.New(c, a.Context, a.HttpClient, a.Logger, a.Database, a.Xray, a.Writer, a.Shipper)
 	a.HttpServer = server.New(c, a.Logger, a.Coordinator, a.Database, a.Enigma, a.Licensor, a.Writer, a.HttpClient)
 
 	a.Logger.Info("app: constructed successfully")
@@ -42,6 +42,40 @@ type Config struct {
 	Xray struct {
 		LogLevel string `json:"log_level" validate:"required,oneof=debug info warning error none"`
 	} `json:"xray" validate:"required"`
+
+	UsageHistory struct {
+		Minutes int `json:"minutes" validate:"min=0,max=1440"`
+		Hours   int `json:"hours" validate:"min=0,max=2160"`
+		Days    int `json:"days" validate:"min=0,max=1096"`
+	} `json:"usage_history"`
+
+	Subscription struct {
+		Title          string `json:"title" validate:"max=64"`
+		UpdateInterval int    `json:"update_interval" validate:"min=1,max=168"`
+		URL            string `json:"url" validate:"omitempty,url"`
+	} `json:"subscription"`
+
+	Database struct {
+		Driver string `json:"driver" validate:"required,oneof=json bolt"`
+		Backup struct {
+			Hourly   int  `json:"hourly" validate:"min=0,max=720"`
+			Daily    int  `json:"daily" validate:"min=0,max=366"`
+			Monthly  int  `json:"monthly" validate:"min=0,max=120"`
+			Manual   int  `json:"manual" validate:"min=0,max=100"`
+			Compress bool `json:"compress"`
+			Remote   struct {
+				Enabled       bool   `json:"enabled"`
+				Endpoint      string `json:"endpoint" validate:"required_if=Enabled true"`
+				Region        string `json:"region"`
+				Bucket        string `json:"bucket" validate:"required_if=Enabled true"`
+				Prefix        string `json:"prefix"`
+				AccessKey     string `json:"access_key"`
+				SecretKey     string `json:"secret_key"`
+				Secure        bool   `json:"secure"`
+				EncryptionKey string `json:"encryption_key" validate:"required_if=Enabled true,omitempty,base64"`
+			} `json:"remote"`
+		} `json:"backup"`
+	} `json:"database" validate:"required"`
 }
 
 func (c *Config) String() string {
@@ -76,7 +110,7 @@ func (c *Config) Init() (err error) {
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		if err = os.WriteFile(c.Env.LocalConfigPath, contentBytes, 0755); err != nil {
+		if err = os.WriteFile(c.Env.LocalConfigPath, contentBytes, 0600); err != nil {
 			return errors.WithStack(err)
 		}
 	}
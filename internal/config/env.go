@@ -11,15 +11,23 @@ var xrayBinaryPaths = map[string]string{
 }
 
 type Env struct {
-	AppDirectory       string
-	LicensePath        string
-	EnigmaKeyPath      string
-	XrayConfigPath     string
-	XrayBinaryPath     string
-	DefaultConfigPath  string
-	LocalConfigPath    string
-	DatabasePath       string
-	DatabaseBackupPath string
+	AppDirectory            string
+	LicensePath             string
+	EnigmaKeyPath           string
+	XrayConfigPath          string
+	XrayRejectedConfigPath  string
+	XrayBinaryPath          string
+	DefaultConfigPath       string
+	LocalConfigPath         string
+	DatabasePath            string
+	DatabaseJournalPath     string
+	DatabaseBoltPath        string
+	DatabaseBackupPath      string
+	DatabaseBackupIndexPath string
+	DatabaseUsagePath       string
+	MasterKeyPath           string
+	DefaultClashPath        string
+	LocalClashPath          string
 }
 
 func NewEnv(appDirectory string) *Env {
@@ -29,14 +37,22 @@ func NewEnv(appDirectory string) *Env {
 	}
 
 	return &Env{
-		AppDirectory:       appDirectory,
-		XrayBinaryPath:     xrayBinaryPath,
-		DefaultConfigPath:  filepath.Join(appDirectory, "configs/main.defaults.json"),
-		LocalConfigPath:    filepath.Join(appDirectory, "configs/main.json"),
-		LicensePath:        filepath.Join(appDirectory, "storage/app/license.txt"),
-		EnigmaKeyPath:      filepath.Join(appDirectory, "resources/ed25519_public_key.txt"),
-		XrayConfigPath:     filepath.Join(appDirectory, "storage/app/xray.json"),
-		DatabasePath:       filepath.Join(appDirectory, "storage/database/app.json"),
-		DatabaseBackupPath: filepath.Join(appDirectory, "storage/database/backup-%s.json"),
+		AppDirectory:            appDirectory,
+		XrayBinaryPath:          xrayBinaryPath,
+		DefaultConfigPath:       filepath.Join(appDirectory, "configs/main.defaults.json"),
+		LocalConfigPath:         filepath.Join(appDirectory, "configs/main.json"),
+		LicensePath:             filepath.Join(appDirectory, "storage/app/license.txt"),
+		EnigmaKeyPath:           filepath.Join(appDirectory, "resources/ed25519_public_key.txt"),
+		XrayConfigPath:          filepath.Join(appDirectory, "storage/app/xray.json"),
+		XrayRejectedConfigPath:  filepath.Join(appDirectory, "storage/app/xray-rejected-%s.json"),
+		DatabasePath:            filepath.Join(appDirectory, "storage/database/app.json"),
+		DatabaseJournalPath:     filepath.Join(appDirectory, "storage/database/app.journal"),
+		DatabaseBoltPath:        filepath.Join(appDirectory, "storage/database/app.db"),
+		DatabaseBackupPath:      filepath.Join(appDirectory, "storage/database/backup-%s.json"),
+		DatabaseBackupIndexPath: filepath.Join(appDirectory, "storage/database/backups.json"),
+		DatabaseUsagePath:       filepath.Join(appDirectory, "storage/database/usage.json"),
+		MasterKeyPath:           filepath.Join(appDirectory, "storage/app/master.key"),
+		DefaultClashPath:        filepath.Join(appDirectory, "configs/clash.defaults.yaml"),
+		LocalClashPath:          filepath.Join(appDirectory, "configs/clash.yaml"),
 	}
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/ebadidev/arch-manager/internal/config"
 	"github.com/ebadidev/arch-manager/internal/database"
 	"github.com/ebadidev/arch-manager/internal/http/client"
+	"github.com/ebadidev/arch-manager/internal/shipper"
 	"github.com/ebadidev/arch-manager/internal/utils"
 	"github.com/ebadidev/arch-manager/internal/writer"
 	"github.com/ebadidev/arch-node/pkg/logger"
@@ -30,6 +31,7 @@ type Coordinator struct {
 	hc      *client.Client
 	xray    *xray.Xray
 	writer  *writer.Writer
+	shipper *shipper.Shipper
 	state   *State
 }
 
@@ -72,12 +74,34 @@ func (c *Coordinator) Run() {
 
 	go newWorker(c.context, time.Hour, func() {
 		c.l.Info("coordinator: running worker to backup d...")
-		c.d.Backup()
+		if backup := c.d.Backup(); backup != nil {
+			if err := c.shipper.Ship(c.context, backup); err != nil {
+				c.l.Error("coordinator: cannot ship backup", zap.Error(errors.WithStack(err)))
+			}
+		}
 	}, func() {
 		c.l.Debug("coordinator: worker for backup d stopped")
 	}).Start()
 
-	go newWorker(c.context, time.Hour, func() {
+	go newWorker(c.context, time.Minute, func() {
+		c.l.Info("coordinator: running worker to disable expired users...")
+		if err := c.disableExpiredUsers(); err != nil {
+			c.l.Error("coordinator: cannot disable expired users", zap.Error(errors.WithStack(err)))
+		}
+	}, func() {
+		c.l.Debug("coordinator: worker for expired users stopped")
+	}).Start()
+
+	go newWorker(c.context, time.Minute, func() {
+		c.l.Info("coordinator: running worker to prune retired credentials...")
+		if err := c.pruneRetiredCredentials(); err != nil {
+			c.l.Error("coordinator: cannot prune retired credentials", zap.Error(errors.WithStack(err)))
+		}
+	}, func() {
+		c.l.Debug("coordinator: worker for retired credentials stopped")
+	}).Start()
+
+	go newWorker(c.context, time.Minute, func() {
 		c.l.Info("coordinator: running worker to reset users...")
 		if err := c.resetUserUsages(); err != nil {
 			c.l.Error("coordinator: cannot reset users usages", zap.Error(errors.WithStack(err)))
@@ -103,6 +127,15 @@ func (c *Coordinator) syncLocalConfig() error {
 		return err
 	}
 
+	// An invalid config would stop the core, so the running config is kept instead.
+	if err = writer.Validate(localConfig); err != nil {
+		c.l.Error("coordinator: local config is invalid, keeping the running config", zap.Error(err))
+		if err = c.writer.SaveRejectedConfig("local", localConfig, err); err != nil {
+			c.l.Error("coordinator: cannot save rejected local config", zap.Error(errors.WithStack(err)))
+		}
+		return nil
+	}
+
 	c.state.xrayUpdatedAt = time.Now()
 
 	c.xray.SetConfig(localConfig)
@@ -113,14 +146,18 @@ func (c *Coordinator) syncLocalConfig() error {
 
 func (c *Coordinator) syncRemoteConfigs() {
 	c.l.Info("coordinator: syncing remote configs...")
-	for _, s := range c.d.Content.Nodes {
+	for _, s := range c.d.Nodes() {
 		go c.syncRemoteConfig(s)
 	}
 }
 
 func (c *Coordinator) syncOutdatedConfigs() {
 	c.l.Info("coordinator: syncing outdated configs...")
-	for _, n := range c.d.Content.Nodes {
+
+	c.d.Locker.Lock()
+	defer c.d.Locker.Unlock()
+
+	for _, n := range c.d.Nodes() {
 		if n.PushStatus == database.NodeStatusUnavailable || n.PushStatus == database.NodeStatusProcessing {
 			go c.syncRemoteConfig(n)
 		}
@@ -129,11 +166,29 @@ func (c *Coordinator) syncOutdatedConfigs() {
 
 func (c *Coordinator) syncRemoteConfig(node *database.Node) {
 	url := fmt.Sprintf("%s://%s:%d/v1/configs", "http", node.Host, node.HttpPort)
-	proxy := c.d.Content.Settings.SingetServer
+	proxy := c.d.Settings().SingetServer
 	proxied := false
 	success := false
 
+	c.d.Locker.Lock()
 	xc := c.writer.RemoteConfig(node, c.state.XrayUpdatedAt(), c.state.XraySharedPassword())
+	validationErr := writer.Validate(xc)
+	if validationErr != nil {
+		node.PushStatus = database.NodeStatusInvalid
+		node.ConfigError = validationErr.Error()
+	} else {
+		node.ConfigError = ""
+	}
+	c.d.Locker.Unlock()
+
+	if validationErr != nil {
+		c.l.Error("coordinator: remote config is invalid", zap.String("url", url), zap.Error(validationErr))
+		err := c.writer.SaveRejectedConfig(fmt.Sprintf("node-%d", node.Id), xc, validationErr)
+		if err != nil {
+			c.l.Error("coordinator: cannot save rejected remote config", zap.Error(errors.WithStack(err)))
+		}
+		return
+	}
 
 	c.l.Info("coordinator: syncing remote config...", zap.String("url", url), zap.String("proxy", proxy))
 
@@ -148,6 +203,9 @@ func (c *Coordinator) syncRemoteConfig(node *database.Node) {
 		}
 	}
 
+	c.d.Locker.Lock()
+	defer c.d.Locker.Unlock()
+
 	if success {
 		node.PushedAt = time.Now().UnixMilli()
 		if proxied {
@@ -175,13 +233,13 @@ func (c *Coordinator) syncRemoteConfig(node *database.Node) {
 }
 
 func (c *Coordinator) syncRemoteStats() {
-	if len(c.d.Content.Nodes) == 0 {
+	if len(c.d.Nodes()) == 0 {
 		c.l.Debug("coordinator: no nodes configured, remote stats disabled")
 		return
 	}
 
 	c.l.Info("coordinator: syncing remote stats...")
-	for _, s := range c.d.Content.Nodes {
+	for _, s := range c.d.Nodes() {
 		go c.syncRemoteNodeStats(s)
 	}
 }
@@ -206,24 +264,34 @@ func (c *Coordinator) syncRemoteNodeStats(node *database.Node) {
 	c.d.Locker.Lock()
 	defer c.d.Locker.Unlock()
 
-	users := map[string]int64{}
+	now := time.Now()
+	users := map[int]int64{}
 	var nodeUsageBytes int64
 
 	for _, qs := range queryStats {
 		parts := strings.Split(qs.GetName(), ">>>")
 		if parts[0] == "user" {
-			users[parts[1]] += qs.GetValue()
-		} else if parts[0] == "inbound" && parts[1] == "remote" {
-			nodeUsageBytes += qs.GetValue()
+			if id, _, ok := writer.ParseClientEmail(parts[1]); ok {
+				users[id] += qs.GetValue()
+			}
+		} else if parts[0] == "inbound" {
+			if writer.IsRemoteInboundTag(parts[1]) {
+				nodeUsageBytes += qs.GetValue()
+			}
 		}
 	}
 
 	shouldSync := false
-	for _, u := range c.d.Content.Users {
-		if bytes, found := users[strconv.Itoa(u.Id)]; found {
+	for _, u := range c.d.Users() {
+		if bytes, found := users[u.Id]; found {
 			u.UsageBytes = utils.SafeSumI64(u.UsageBytes, bytes)
 			u.Usage = utils.Bytes2GB(u.UsageBytes)
-			if u.Quota > 0 && u.Usage > u.Quota {
+			if bytes > 0 {
+				u.MarkUsed(now)
+			}
+			u.AddNodeUsage(node.Id, bytes)
+			c.d.RecordUsage(u.Id, bytes, now)
+			if u.OverQuota() {
 				u.Enabled = false
 				shouldSync = true
 				c.l.Debug("coordinator: user disabled", zap.Int("id", u.Id))
@@ -237,12 +305,16 @@ func (c *Coordinator) syncRemoteNodeStats(node *database.Node) {
 	node.UsageBytes = utils.SafeSumI64(node.UsageBytes, nodeUsageBytes)
 	node.Usage = utils.Bytes2GB(node.UsageBytes)
 
-	c.d.Content.Stats.TotalUsageBytes = utils.SafeSumI64(c.d.Content.Stats.TotalUsageBytes, nodeUsageBytes)
-	c.d.Content.Stats.TotalUsage = utils.Bytes2GB(c.d.Content.Stats.TotalUsageBytes)
+	stats := c.d.Stats()
+	stats.TotalUsageBytes = utils.SafeSumI64(stats.TotalUsageBytes, nodeUsageBytes)
+	stats.TotalUsage = utils.Bytes2GB(stats.TotalUsageBytes)
 
 	if err = c.d.Save(); err != nil {
 		c.l.Error("cannot save remote node stats", zap.String("url", url), zap.Error(errors.WithStack(err)))
 	}
+	if err = c.d.FlushUsageHistories(now); err != nil {
+		c.l.Error("cannot save usage histories", zap.Error(errors.WithStack(err)))
+	}
 }
 
 func (c *Coordinator) syncLocalStats() error {
@@ -256,37 +328,55 @@ func (c *Coordinator) syncLocalStats() error {
 	c.d.Locker.Lock()
 	defer c.d.Locker.Unlock()
 
+	now := time.Now()
+	stats := c.d.Stats()
 	nodes := map[string]int64{}
-	users := map[string]int64{}
+	users := map[int]map[int]int64{}
 
 	for _, qs := range queryStats {
 		parts := strings.Split(qs.GetName(), ">>>")
 		if parts[0] == "user" {
-			users[parts[1]] += qs.GetValue()
+			if id, nodeId, ok := writer.ParseClientEmail(parts[1]); ok {
+				if users[id] == nil {
+					users[id] = map[int]int64{}
+				}
+				users[id][nodeId] += qs.GetValue()
+			}
 		} else if parts[0] == "inbound" && strings.HasPrefix(parts[1], "internal-") {
 			nodes[parts[1][8:]] += qs.GetValue()
 		} else if parts[0] == "outbound" && strings.HasPrefix(parts[1], "relay-") {
 			nodes[parts[1][6:]] += qs.GetValue()
 		} else if parts[0] == "inbound" && slices.Contains([]string{"reverse", "relay", "direct"}, parts[1]) {
-			c.d.Content.Stats.TotalUsageBytes = utils.SafeSumI64(c.d.Content.Stats.TotalUsageBytes, qs.GetValue())
+			stats.TotalUsageBytes = utils.SafeSumI64(stats.TotalUsageBytes, qs.GetValue())
 		}
 	}
 
-	for _, n := range c.d.Content.Nodes {
+	for _, n := range c.d.Nodes() {
 		if bytes, found := nodes[strconv.Itoa(n.Id)]; found {
 			n.UsageBytes = utils.SafeSumI64(n.UsageBytes, bytes)
 		}
 		n.Usage = utils.Bytes2GB(n.UsageBytes)
 	}
 
-	c.d.Content.Stats.TotalUsage = utils.Bytes2GB(c.d.Content.Stats.TotalUsageBytes)
+	stats.TotalUsage = utils.Bytes2GB(stats.TotalUsageBytes)
 
 	shouldSync := false
-	for _, u := range c.d.Content.Users {
-		if bytes, found := users[strconv.Itoa(u.Id)]; found {
+	for _, u := range c.d.Users() {
+		if usages, found := users[u.Id]; found {
+			var bytes int64
+			for nodeId, nodeBytes := range usages {
+				bytes += nodeBytes
+				if nodeId != 0 {
+					u.AddNodeUsage(nodeId, nodeBytes)
+				}
+			}
 			u.UsageBytes = utils.SafeSumI64(u.UsageBytes, bytes)
 			u.Usage = utils.Bytes2GB(u.UsageBytes)
-			if u.Quota > 0 && u.Usage > u.Quota {
+			if bytes > 0 {
+				u.MarkUsed(now)
+			}
+			c.d.RecordUsage(u.Id, bytes, now)
+			if u.OverQuota() {
 				u.Enabled = false
 				shouldSync = true
 				c.l.Debug("coordinator: user disabled", zap.Int("id", u.Id))
@@ -297,15 +387,25 @@ func (c *Coordinator) syncLocalStats() error {
 		go c.SyncConfigs()
 	}
 
-	err = c.d.Save()
+	if err = c.d.Save(); err != nil {
+		return errors.WithStack(err)
+	}
+	err = c.d.FlushUsageHistories(now)
 	return errors.WithStack(err)
 }
 
 func (c *Coordinator) syncNodePullStatuses() error {
 	c.l.Info("coordinator: syncing pull statuses...")
 
+	c.d.Locker.Lock()
+	defer c.d.Locker.Unlock()
+
+	// Invalid configs keep the nodes invalid until they pull a valid one
 	needsSync := false
-	for _, n := range c.d.Content.Nodes {
+	for _, n := range c.d.Nodes() {
+		if n.PullStatus == database.NodeStatusInvalid {
+			continue
+		}
 		if time.Now().Sub(time.UnixMilli(n.PulledAt)) > time.Minute && n.PullStatus != database.NodeStatusUnavailable {
 			c.l.Info(fmt.Sprintf("Node %d marked as unavailable", n.Id))
 			n.PullStatus = database.NodeStatusUnavailable
@@ -321,23 +421,75 @@ func (c *Coordinator) syncNodePullStatuses() error {
 	return nil
 }
 
-func (c *Coordinator) resetUserUsages() error {
-	if c.d.Content.Settings.ResetPolicy != "monthly" {
+func (c *Coordinator) disableExpiredUsers() error {
+	c.d.Locker.Lock()
+	defer c.d.Locker.Unlock()
+
+	now := time.Now()
+	shouldSync := false
+	for _, u := range c.d.Users() {
+		if u.Enabled && u.Expired(now) {
+			u.Enabled = false
+			shouldSync = true
+			c.l.Debug("coordinator: user expired", zap.Int("id", u.Id))
+		}
+	}
+
+	if !shouldSync {
+		return nil
+	}
+	if err := c.d.Save(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	go c.SyncConfigs()
+
+	return nil
+}
+
+// pruneRetiredCredentials removes the credentials whose grace period has ended, from the database and the nodes.
+func (c *Coordinator) pruneRetiredCredentials() error {
+	c.d.Locker.Lock()
+	defer c.d.Locker.Unlock()
+
+	if !c.d.PruneRetiredCredentials(time.Now()) {
 		return nil
 	}
+	if err := c.d.Save(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	go c.SyncConfigs()
+
+	return nil
+}
+
+func (c *Coordinator) resetUserUsages() error {
+	c.d.Locker.Lock()
+	defer c.d.Locker.Unlock()
 
 	c.l.Info("coordinator: resetting users usages...")
 
-	for _, u := range c.d.Content.Users {
-		if time.Unix(u.UsageResetAt, 0).Format("2006-01") == time.Now().Format("2006-01") {
+	now := time.Now()
+	loc := c.d.Settings().Location()
+	shouldSync := false
+	for _, u := range c.d.Users() {
+		if !u.UsageResetDue(now, loc) {
 			continue
 		}
-		u.Usage = 0
-		u.UsageBytes = 0
-		u.Enabled = true
-		u.UsageResetAt = time.Now().Unix()
+		// Users disabled by hand stay disabled, only the ones disabled for their usage get back.
+		if !u.Enabled && u.OverQuota() && !u.Expired(now) {
+			u.Enabled = true
+		}
+		u.ResetUsage()
+		u.UsageResetAt = now.UnixMilli()
+		shouldSync = true
+		c.l.Debug("coordinator: user usage reset", zap.Int("id", u.Id), zap.String("strategy", u.ResetStrategy))
 	}
 
+	if !shouldSync {
+		return nil
+	}
 	if err := c.d.Save(); err != nil {
 		return errors.WithStack(err)
 	}
@@ -359,6 +511,7 @@ func New(
 	database *database.Database,
 	xray *xray.Xray,
 	writer *writer.Writer,
+	shipper *shipper.Shipper,
 ) *Coordinator {
 	return &Coordinator{
 		l:       logger,
@@ -368,6 +521,7 @@ func New(
 		d:       database,
 		xray:    xray,
 		writer:  writer,
+		shipper: shipper,
 		state:   NewState(),
 	}
 }
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	BackupTierHourly     = "hourly"
+	BackupTierDaily      = "daily"
+	BackupTierMonthly    = "monthly"
+	BackupTierManual     = "manual"
+	BackupTierPreRestore = "pre-restore"
+)
+
+// BackupNamePattern matches the file names of backups, it keeps names from escaping the database directory.
+var BackupNamePattern = regexp.MustCompile(`^backup-[a-z0-9-]+\.json(\.gz)?$`)
+
+var ErrBackupNotFound = errors.New("backup not found")
+
+var backupFilePattern = regexp.MustCompile(`^backup-(hourly|daily|monthly|manual|pre-restore)-(\d{8}-\d{6})\.json(\.gz)?$`)
+
+// Backup describes a backup file listed in the backup index.
+type Backup struct {
+	Name          string `json:"name"`
+	Tier          string `json:"tier"`
+	Size          int64  `json:"size"`
+	Compressed    bool   `json:"compressed"`
+	SchemaVersion int    `json:"schema_version"`
+	CreatedAt     int64  `json:"created_at"`
+}
+
+// Backup takes the scheduled backup of the content and returns it, or nil when it fails.
+// The first backup of a month is kept as monthly, the first of a day as daily, and the rest as hourly.
+func (d *Database) Backup() *Backup {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	index, err := d.backupIndex()
+	if err != nil {
+		d.l.Error("database: cannot read backup index", zap.Error(errors.WithStack(err)))
+		return nil
+	}
+
+	now := time.Now()
+	tier := BackupTierHourly
+	if !slices.ContainsFunc(index, func(b *Backup) bool {
+		return b.Tier == BackupTierMonthly && time.UnixMilli(b.CreatedAt).Format("2006-01") == now.Format("2006-01")
+	}) {
+		tier = BackupTierMonthly
+	} else if !slices.ContainsFunc(index, func(b *Backup) bool {
+		return b.Tier != BackupTierHourly && time.UnixMilli(b.CreatedAt).Format("2006-01-02") == now.Format("2006-01-02")
+	}) {
+		tier = BackupTierDaily
+	}
+
+	backup, err := d.backup(tier)
+	if err != nil {
+		d.l.Error("database: cannot save backup", zap.String("tier", tier), zap.Error(errors.WithStack(err)))
+		return nil
+	}
+	return backup
+}
+
+// CreateBackup takes an on-demand backup of the content.
+func (d *Database) CreateBackup() (*Backup, error) {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	return d.backup(BackupTierManual)
+}
+
+// Backups returns the backups in the index, the newest first.
+func (d *Database) Backups() ([]*Backup, error) {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	index, err := d.backupIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(index)
+	return index, nil
+}
+
+// FindBackup returns the backup with the given name and the path of its file.
+func (d *Database) FindBackup(name string) (*Backup, string, error) {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	return d.findBackup(name)
+}
+
+// RestoreBackup replaces the content with the backup of the given name.
+// The current content is backed up first, so the restore itself can be undone.
+func (d *Database) RestoreBackup(name string) (*Backup, error) {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	backup, path, err := d.findBackup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := readBackupFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := d.Content
+	d.Content = newContent()
+	if content, _, err = d.migrate(content, false); err == nil {
+		err = d.decode(content)
+	}
+	restored := d.Content
+	d.Content = current
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid backup")
+	}
+
+	if _, err = d.backup(BackupTierPreRestore); err != nil {
+		return nil, errors.Wrap(err, "cannot back up before restore")
+	}
+
+	d.Content = restored
+	if err = d.Save(); err != nil {
+		return nil, err
+	}
+
+	d.l.Info("database: restored from backup", zap.String("name", name))
+	return backup, nil
+}
+
+// backup writes the content to a new backup file of the given tier and prunes the expired backups.
+func (d *Database) backup(tier string) (*Backup, error) {
+	sealed, err := d.sealContent()
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(sealed)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	compress := d.c.Database.Backup.Compress
+	if compress {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err = writer.Write(content); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err = writer.Close(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		content = buffer.Bytes()
+	}
+
+	now := time.Now()
+	path := fmt.Sprintf(d.c.Env.DatabaseBackupPath, tier+"-"+now.Format("20060102-150405"))
+	if compress {
+		path += ".gz"
+	}
+	if err = utils.WriteFileAtomic(path, content, 0600); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	backup := &Backup{
+		Name:          filepath.Base(path),
+		Tier:          tier,
+		Size:          int64(len(content)),
+		Compressed:    compress,
+		SchemaVersion: d.Content.SchemaVersion,
+		CreatedAt:     now.UnixMilli(),
+	}
+
+	index, err := d.backupIndex()
+	if err != nil {
+		return nil, err
+	}
+	index = slices.DeleteFunc(index, func(b *Backup) bool {
+		return b.Name == backup.Name
+	})
+	index = append(index, backup)
+
+	return backup, d.saveBackupIndex(d.prune(index))
+}
+
+// BackupRetention returns how many backups of each tier are kept.
+func (d *Database) BackupRetention() map[string]int {
+	return map[string]int{
+		BackupTierHourly:     d.c.Database.Backup.Hourly,
+		BackupTierDaily:      d.c.Database.Backup.Daily,
+		BackupTierMonthly:    d.c.Database.Backup.Monthly,
+		BackupTierManual:     d.c.Database.Backup.Manual,
+		BackupTierPreRestore: d.c.Database.Backup.Manual,
+	}
+}
+
+// ExpiredBackups returns the oldest backups of each tier beyond the given retention.
+// The backups must be sorted the oldest first.
+func ExpiredBackups(backups []*Backup, retention map[string]int) []*Backup {
+	var expired []*Backup
+	counts := map[string]int{}
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		counts[b.Tier]++
+		if limit, found := retention[b.Tier]; found && counts[b.Tier] > limit {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// prune removes the backups expired by the retention from the disk and the given index.
+func (d *Database) prune(index []*Backup) []*Backup {
+	for _, b := range ExpiredBackups(index, d.BackupRetention()) {
+		path := filepath.Join(filepath.Dir(d.c.Env.DatabaseBackupPath), b.Name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			d.l.Error("database: cannot remove expired backup", zap.String("file", path), zap.Error(err))
+			continue
+		}
+		index = slices.DeleteFunc(index, func(i *Backup) bool {
+			return i == b
+		})
+	}
+	return index
+}
+
+func (d *Database) findBackup(name string) (*Backup, string, error) {
+	if !BackupNamePattern.MatchString(name) {
+		return nil, "", errors.WithStack(ErrBackupNotFound)
+	}
+
+	index, err := d.backupIndex()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, b := range index {
+		if b.Name == name {
+			return b, filepath.Join(filepath.Dir(d.c.Env.DatabaseBackupPath), b.Name), nil
+		}
+	}
+	return nil, "", errors.WithStack(ErrBackupNotFound)
+}
+
+// backupIndex returns the backups in the index, the oldest first.
+// A missing or unreadable index is rebuilt from the backup files on the disk.
+func (d *Database) backupIndex() ([]*Backup, error) {
+	content, err := os.ReadFile(d.c.Env.DatabaseBackupIndexPath)
+	if err == nil {
+		var index []*Backup
+		if err = json.Unmarshal(content, &index); err == nil {
+			return index, nil
+		}
+	}
+	if !os.IsNotExist(err) {
+		d.l.Error("database: cannot read backup index, rebuilding...", zap.Error(errors.WithStack(err)))
+	}
+
+	return d.scanBackups()
+}
+
+// scanBackups lists the backup files on the disk, the oldest first.
+// Backups of the older weekday-hour naming are not listed and are left as they are.
+func (d *Database) scanBackups() ([]*Backup, error) {
+	paths, err := filepath.Glob(fmt.Sprintf(d.c.Env.DatabaseBackupPath, "*") + "*")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	index := []*Backup{}
+	for _, path := range paths {
+		backup, ok := ParseBackupName(filepath.Base(path))
+		if !ok {
+			continue
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		backup.Size = stat.Size()
+		index = append(index, backup)
+	}
+
+	slices.SortStableFunc(index, func(a, b *Backup) int {
+		return int(a.CreatedAt - b.CreatedAt)
+	})
+	return index, nil
+}
+
+func (d *Database) saveBackupIndex(index []*Backup) error {
+	content, err := json.Marshal(index)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(utils.WriteFileAtomic(d.c.Env.DatabaseBackupIndexPath, content, 0600))
+}
+
+// ParseBackupName returns the backup described by the given file name of the tiered naming.
+func ParseBackupName(name string) (*Backup, bool) {
+	matches := backupFilePattern.FindStringSubmatch(name)
+	if matches == nil {
+		return nil, false
+	}
+	createdAt, err := time.ParseInLocation("20060102-150405", matches[2], time.Local)
+	if err != nil {
+		return nil, false
+	}
+
+	return &Backup{
+		Name:       name,
+		Tier:       matches[1],
+		Compressed: matches[3] != "",
+		CreatedAt:  createdAt.UnixMilli(),
+	}, true
+}
+
+// readBackupFile returns the content document in the given backup file, decompressing it if needed.
+func readBackupFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil || !strings.HasSuffix(path, ".gz") {
+		return content, errors.WithStack(err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	content, err = io.ReadAll(reader)
+	return content, errors.WithStack(err)
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Credential kinds of a user that can be rotated.
+const (
+	CredentialShadowsocks = "shadowsocks"
+	CredentialUUID        = "uuid"
+	CredentialTrojan      = "trojan"
+	CredentialIdentity    = "identity"
+)
+
+// RetiredCredential is a rotated credential of a user that keeps working until ExpiresAt,
+// so the user has time to update its clients.
+type RetiredCredential struct {
+	Kind      string `json:"kind"`
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// RetiredCredentials returns the retired credentials of the given kind that still work at the given time.
+func (u *User) RetiredCredentials(kind string, now time.Time) []string {
+	var values []string
+	for _, c := range u.Retired {
+		if c.Kind == kind && c.ExpiresAt > now.UnixMilli() {
+			values = append(values, c.Value)
+		}
+	}
+	return values
+}
+
+// Retiring returns a copy of the user as the clients of the given retired identity know it: with that identity, and
+// the oldest retired credentials still in their grace period in place of the current ones, so the subscription of the
+// retired identity keeps serving the old links without handing out the rotated identity and credentials.
+func (u *User) Retiring(identity string, now time.Time) *User {
+	retiring := *u
+	retiring.Identity = identity
+	for kind, credential := range map[string]*string{
+		CredentialShadowsocks: &retiring.ShadowsocksPassword,
+		CredentialUUID:        &retiring.UUID,
+		CredentialTrojan:      &retiring.TrojanPassword,
+	} {
+		if values := u.RetiredCredentials(kind, now); len(values) > 0 {
+			*credential = values[0]
+		}
+	}
+	return &retiring
+}
+
+// RotateCredential replaces the credential of the given kind with a new one.
+// With a grace period, the previous credential keeps working until it ends.
+func (d *Database) RotateCredential(user *User, kind string, grace time.Duration, now time.Time) error {
+	var credential *string
+	var value string
+	switch kind {
+	case CredentialShadowsocks:
+		credential, value = &user.ShadowsocksPassword, d.GenerateUserPassword()
+	case CredentialUUID:
+		credential, value = &user.UUID, d.GenerateUserUUID()
+	case CredentialTrojan:
+		credential, value = &user.TrojanPassword, d.GenerateUserTrojanPassword()
+	case CredentialIdentity:
+		credential, value = &user.Identity, d.GenerateUserIdentity()
+	default:
+		return errors.Errorf("unknown credential kind %s", kind)
+	}
+
+	if grace > 0 {
+		user.Retired = append(user.Retired, &RetiredCredential{
+			Kind:      kind,
+			Value:     *credential,
+			ExpiresAt: now.Add(grace).UnixMilli(),
+		})
+	}
+	*credential = value
+
+	return nil
+}
+
+// PruneRetiredCredentials removes the retired credentials whose grace period has ended, and reports whether there were any.
+func (d *Database) PruneRetiredCredentials(now time.Time) bool {
+	pruned := false
+	for _, u := range d.Content.Users {
+		count := len(u.Retired)
+		u.Retired = slices.DeleteFunc(u.Retired, func(c *RetiredCredential) bool {
+			return c.ExpiresAt <= now.UnixMilli()
+		})
+		pruned = pruned || len(u.Retired) < count
+	}
+	return pruned
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetiredIdentity(t *testing.T) {
+	now := time.Now()
+	d := &Database{Content: &Content{}}
+	user := &User{
+		Id:                  1,
+		Identity:            "old",
+		ShadowsocksPassword: "old-ss",
+		UUID:                "old-uuid",
+		TrojanPassword:      "old-trojan",
+	}
+	d.AddUser(user)
+
+	for _, kind := range []string{CredentialIdentity, CredentialUUID, CredentialTrojan} {
+		if err := d.RotateCredential(user, kind, time.Hour, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if d.FindUserByIdentity("old") != nil {
+		t.Error("the retired identity finds the user by identity")
+	}
+	if d.FindUserByIdentity(user.Identity) != user {
+		t.Error("the current identity does not find the user")
+	}
+	if d.FindUserByRetiredIdentity("old", now) != user {
+		t.Error("the retired identity does not find the user in its grace period")
+	}
+	if d.FindUserByRetiredIdentity("old", now.Add(2*time.Hour)) != nil {
+		t.Error("the retired identity finds the user after its grace period")
+	}
+	if d.FindUserByRetiredIdentity(user.Identity, now) != nil {
+		t.Error("the current identity finds the user as a retired one")
+	}
+
+	retiring := user.Retiring("old", now)
+	if retiring.Identity != "old" || retiring.UUID != "old-uuid" || retiring.TrojanPassword != "old-trojan" {
+		t.Errorf("got %s, %s and %s, want the retired credentials", retiring.Identity, retiring.UUID, retiring.TrojanPassword)
+	}
+	if retiring.ShadowsocksPassword != "old-ss" {
+		t.Errorf("got %s, want the credential that was not rotated", retiring.ShadowsocksPassword)
+	}
+	if user.Identity == "old" || user.UUID == "old-uuid" {
+		t.Error("the user itself was changed")
+	}
+}
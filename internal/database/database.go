@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -13,97 +14,180 @@ import (
 	"github.com/ebadidev/arch-manager/internal/utils"
 	"github.com/ebadidev/arch-node/pkg/logger"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 	"github.com/labstack/gommon/random"
 	"go.uber.org/zap"
 )
 
 type Content struct {
-	Settings *Settings `json:"settings"`
-	Stats    *Stats    `json:"stats"`
-	Users    []*User   `json:"users"`
-	Nodes    []*Node   `json:"nodes"`
+	SchemaVersion int       `json:"schema_version"`
+	Keyring       *Keyring  `json:"keyring,omitempty"`
+	Settings      *Settings `json:"settings"`
+	Stats         *Stats    `json:"stats"`
+	Users         []*User   `json:"users"`
+	Nodes         []*Node   `json:"nodes"`
 }
 
 type Database struct {
 	Content *Content
 	Locker  *sync.Mutex
+	storage Storage
 	l       *logger.Logger
 	c       *config.Config
+
+	masterKey []byte
+	dataKey   []byte
+	keyring   *Keyring
+	sealed    map[string]string
+
+	usageHistories []*UsageHistory
+	usageFlushedAt time.Time
 }
 
 func (d *Database) Init() error {
 	d.Locker.Lock()
 	defer d.Locker.Unlock()
 
-	if utils.FileExist(d.c.Env.DatabasePath) {
-		err := d.Load()
+	if err := d.loadUsageHistories(); err != nil {
 		return errors.WithStack(err)
 	}
 
+	if d.storage.Exists() {
+		err := d.Load()
+		if err == nil {
+			return nil
+		}
+		// Backups are no better with the wrong key, and older plaintext ones would silently replace the content.
+		if errors.Is(err, ErrMasterKeyMismatch) {
+			return errors.WithStack(err)
+		}
+		d.l.Error("database: cannot load the storage, recovering...", zap.Error(errors.WithStack(err)))
+		return errors.WithStack(d.recover(err))
+	}
+
+	// Carry the content over when switching from the JSON file to another storage.
+	legacy := NewJsonStorage(d.c.Env.DatabasePath, d.c.Env.DatabaseJournalPath)
+	if d.c.Database.Driver != "json" && legacy.Exists() {
+		d.l.Info("database: importing the json file into the storage", zap.String("driver", d.c.Database.Driver))
+		content, err := legacy.Load()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if content, _, err = d.migrate(content, false); err != nil {
+			return errors.WithStack(err)
+		}
+		if err = d.decode(content); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	err := d.Save()
 	return errors.WithStack(err)
 }
 
 func (d *Database) Load() error {
-	content, err := os.ReadFile(d.c.Env.DatabasePath)
+	content, err := d.storage.Load()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	content, results, err := d.migrate(content, true)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	err = json.Unmarshal(content, d.Content)
+	if err = d.decode(content); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Migrated content and plaintext secrets of older versions are written back right away.
+	if len(results) > 0 || d.Content.Keyring == nil {
+		return errors.WithStack(d.Save())
+	}
+	return nil
+}
+
+// decode binds the given content document, which must be at the latest schema version, to the content.
+func (d *Database) decode(content []byte) error {
+	err := json.Unmarshal(content, d.Content)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	d.modify()
+	if err = d.openSecrets(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = d.adoptUsageHistories(content); err != nil {
+		return errors.WithStack(err)
+	}
 
 	err = validator.New().Struct(d)
 	return errors.WithStack(err)
 }
 
-func (d *Database) modify() {
-	for _, user := range d.Content.Users {
-		if user.UsageResetAt == 0 {
-			user.UsageResetAt = time.Now().UnixMilli()
+// recover restores the content from the newest valid backup when the storage cannot be loaded.
+// The unreadable storage is moved aside rather than deleted, so it can still be inspected.
+func (d *Database) recover(cause error) error {
+	paths, err := filepath.Glob(fmt.Sprintf(d.c.Env.DatabaseBackupPath, "*") + "*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	modTimes := map[string]time.Time{}
+	for _, path := range paths {
+		if stat, err := os.Stat(path); err == nil {
+			modTimes[path] = stat.ModTime()
 		}
 	}
+	slices.SortFunc(paths, func(a, b string) int {
+		return modTimes[b].Compare(modTimes[a])
+	})
+
+	for _, path := range paths {
+		content, err := readBackupFile(path)
+		if err == nil {
+			d.Content = newContent()
+			if content, _, err = d.migrate(content, false); err == nil {
+				err = d.decode(content)
+			}
+		}
+		if err != nil {
+			d.l.Error("database: skipping invalid backup", zap.String("file", path), zap.Error(errors.WithStack(err)))
+			continue
+		}
+
+		d.l.Info("database: recovered from backup", zap.String("file", path))
+		if err = d.storage.Reset(); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(d.Save())
+	}
+
+	return errors.Wrap(cause, "no valid backup to recover from")
 }
 
 func (d *Database) Save() error {
-	content, err := json.Marshal(d.Content)
+	content, err := d.sealContent()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	err = os.WriteFile(d.c.Env.DatabasePath, content, 0755)
+	err = d.storage.Save(content)
 	return errors.WithStack(err)
 }
 
 func (d *Database) Close() {
-	content, err := json.Marshal(d.Content)
-	if err != nil {
-		d.l.Error("database: close: cannot marshal data", zap.Error(errors.WithStack(err)))
-	}
-
-	if err = os.WriteFile(d.c.Env.DatabasePath, content, 0755); err != nil {
-		d.l.Error("database: close: cannot save file", zap.Error(errors.WithStack(err)))
+	if err := d.Save(); err != nil {
+		d.l.Error("database: close: cannot save content", zap.Error(errors.WithStack(err)))
 	}
-}
-
-func (d *Database) Backup() {
-	d.Locker.Lock()
-	defer d.Locker.Unlock()
 
-	content, err := json.Marshal(d.Content)
-	if err != nil {
-		d.l.Error("database: cannot marshal data", zap.Error(errors.WithStack(err)))
+	if err := d.saveUsageHistories(); err != nil {
+		d.l.Error("database: close: cannot save usage histories", zap.Error(errors.WithStack(err)))
 	}
 
-	path := strings.ToLower(fmt.Sprintf(d.c.Env.DatabaseBackupPath, time.Now().Format("Mon-15")))
-	if err = os.WriteFile(path, content, 0755); err != nil {
-		d.l.Fatal(
-			"database: cannot save backup file", zap.String("file", path), zap.Error(errors.WithStack(err)),
-		)
+	if err := d.storage.Close(); err != nil {
+		d.l.Error("database: close: cannot close storage", zap.Error(errors.WithStack(err)))
 	}
 }
 
@@ -151,6 +235,16 @@ func (d *Database) GenerateUserPassword() string {
 	}
 }
 
+// GenerateUserUUID returns a random UUID for the VLESS and VMess clients of a user.
+func (d *Database) GenerateUserUUID() string {
+	return uuid.New().String()
+}
+
+// GenerateUserTrojanPassword returns a random password for the Trojan clients of a user.
+func (d *Database) GenerateUserTrojanPassword() string {
+	return utils.UUID()
+}
+
 func (d *Database) GenerateNodeId() int {
 	if len(d.Content.Nodes) > 0 {
 		return d.Content.Nodes[len(d.Content.Nodes)-1].Id + 1
@@ -161,36 +255,42 @@ func (d *Database) GenerateNodeId() int {
 
 func New(l *logger.Logger, c *config.Config) *Database {
 	return &Database{
-		Locker: &sync.Mutex{},
-		l:      l,
-		c:      c,
-		Content: &Content{
-			Settings: &Settings{
-				AdminPassword: "password",
-				Host:          "127.0.0.1",
-				TrafficRatio:  1,
-				EncryptionOptions: EncryptionOptions{
-					VMess:  []string{"auto", "none", "zero", "aes-128-gcm"},
-					VLESS:  []string{"none"},
-					Trojan: []string{"none"},
-					SS: []string{
-						"aes-128-gcm",
-						"aes-256-gcm",
-						"chacha20-poly1305",
-						"xchacha20-poly1305",
-						"chacha20-ietf-poly1305",
-						"2022-blake3-aes-128-gcm",
-						"2022-blake3-aes-256-gcm",
-					},
+		Locker:  &sync.Mutex{},
+		storage: NewStorage(c),
+		l:       l,
+		c:       c,
+		Content: newContent(),
+	}
+}
+
+func newContent() *Content {
+	return &Content{
+		SchemaVersion: LatestSchemaVersion(),
+		Settings: &Settings{
+			AdminPassword: "password",
+			Host:          "127.0.0.1",
+			TrafficRatio:  1,
+			EncryptionOptions: EncryptionOptions{
+				VMess:  []string{"auto", "none", "zero", "aes-128-gcm"},
+				VLESS:  []string{"none"},
+				Trojan: []string{"none"},
+				SS: []string{
+					"aes-128-gcm",
+					"aes-256-gcm",
+					"chacha20-poly1305",
+					"xchacha20-poly1305",
+					"chacha20-ietf-poly1305",
+					"2022-blake3-aes-128-gcm",
+					"2022-blake3-aes-256-gcm",
 				},
 			},
-			Stats: &Stats{
-				TotalUsage:        0,
-				TotalUsageBytes:   0,
-				TotalUsageResetAt: time.Now().UnixMilli(),
-			},
-			Users: []*User{},
-			Nodes: []*Node{},
 		},
+		Stats: &Stats{
+			TotalUsage:        0,
+			TotalUsageBytes:   0,
+			TotalUsageResetAt: time.Now().UnixMilli(),
+		},
+		Users: []*User{},
+		Nodes: []*Node{},
 	}
 }
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-node/pkg/logger"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	// The logger writes to the storage of the working directory
+	directory := t.TempDir()
+	t.Chdir(directory)
+	t.Setenv(MasterKeyEnv, "")
+	for _, path := range []string{"storage/logs", "storage/database", "storage/app"} {
+		if err := os.MkdirAll(filepath.Join(directory, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := logger.New("error", "2006-01-02 15:04:05", nil)
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &config.Config{Env: config.NewEnv(directory)}
+	c.Database.Driver = "json"
+	return &Database{
+		Locker:  &sync.Mutex{},
+		storage: NewStorage(c),
+		l:       l,
+		c:       c,
+		Content: newContent(),
+	}
+}
+
+func writeTestBackup(t *testing.T, d *Database, name string, content []byte, modTime time.Time) {
+	t.Helper()
+	path := fmt.Sprintf(d.c.Env.DatabaseBackupPath, name)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	d := newTestDatabase(t)
+	if err := os.WriteFile(d.c.Env.DatabasePath, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i, host := range []string{"older", "newest-valid"} {
+		content, err := json.Marshal(testContent(host))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestBackup(t, d, fmt.Sprintf("hourly-2024010%d-000000", i+1), content, now.Add(time.Duration(i-3)*time.Hour))
+	}
+	writeTestBackup(t, d, "hourly-20240103-000000", []byte("{"), now.Add(-time.Hour))
+
+	cause := errors.New("cannot load")
+	if err := d.recover(cause); err != nil {
+		t.Fatal(err)
+	}
+	if d.Content.Settings.Host != "newest-valid" {
+		t.Errorf("got the host %s, want the one of the newest valid backup", d.Content.Settings.Host)
+	}
+
+	// The unreadable storage is moved aside and the recovered content is saved
+	corrupt, err := filepath.Glob(d.c.Env.DatabasePath + ".corrupt-*")
+	if err != nil || len(corrupt) != 1 {
+		t.Errorf("got the moved storage %v: %v", corrupt, err)
+	}
+	d.Content = newContent()
+	if err = d.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Content.Settings.Host != "newest-valid" {
+		t.Errorf("got the host %s after a load, want newest-valid", d.Content.Settings.Host)
+	}
+}
+
+func TestRecoverWithoutValidBackup(t *testing.T) {
+	d := newTestDatabase(t)
+	writeTestBackup(t, d, "hourly-20240101-000000", []byte("{"), time.Now())
+
+	cause := errors.New("cannot load")
+	if err := d.recover(cause); !errors.Is(err, cause) {
+		t.Errorf("got %v, want the cause", err)
+	}
+}
@@ -0,0 +1,408 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Document is the raw content as decoded from the storage, before it is bound to the Content struct.
+// Numbers are kept as json.Number, so int64 counters survive the round trip.
+type Document = map[string]interface{}
+
+// Migration upgrades the content document from the previous schema version to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(document Document) error
+}
+
+// MigrationResult describes a migration applied to the content document.
+type MigrationResult struct {
+	Version     int      `json:"version"`
+	Description string   `json:"description"`
+	Diff        []string `json:"diff"`
+}
+
+// migrations is the ordered registry of schema migrations; new ones are appended with the next version.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "backfill users usage_reset_at",
+		Up:          migrateUsageResetAt,
+	},
+	{
+		Version:     2,
+		Description: "move legacy shadowsocks nodes to the multi-protocol node layout",
+		Up:          migrateLegacyNodes,
+	},
+	{
+		Version:     3,
+		Description: "store users usage_reset_at in milliseconds and give users their own reset_strategy",
+		Up:          migrateResetStrategies,
+	},
+	{
+		Version:     4,
+		Description: "give users their own uuid and trojan_password",
+		Up:          migrateUserCredentials,
+	},
+	{
+		Version:     5,
+		Description: "move the protocol, network and security settings of nodes to their first inbound",
+		Up:          migrateNodeInbounds,
+	},
+}
+
+// LatestSchemaVersion returns the schema version of the content written by this build.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// migrate upgrades the given content document to the latest schema version.
+// When backup is set, the document is backed up before each migration.
+func (d *Database) migrate(content []byte, backup bool) ([]byte, []*MigrationResult, error) {
+	document, err := decodeDocument(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	version := 0
+	if number, ok := document["schema_version"].(json.Number); ok {
+		if v, err := number.Int64(); err == nil {
+			version = int(v)
+		}
+	}
+	if version > LatestSchemaVersion() {
+		return nil, nil, errors.Errorf("schema version %d is newer than the supported %d", version, LatestSchemaVersion())
+	}
+
+	var results []*MigrationResult
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		before, err := encodeDocument(document)
+		if err != nil {
+			return nil, nil, err
+		}
+		if backup {
+			path := fmt.Sprintf(d.c.Env.DatabaseBackupPath, fmt.Sprintf("v%d-%s", version, time.Now().Format("20060102-150405")))
+			if err = utils.WriteFileAtomic(path, before, 0600); err != nil {
+				return nil, nil, errors.Wrapf(err, "cannot back up before migration %d", m.Version)
+			}
+		}
+
+		snapshot, err := decodeDocument(before)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = m.Up(document); err != nil {
+			return nil, nil, errors.Wrapf(err, "migration %d failed", m.Version)
+		}
+		document["schema_version"] = m.Version
+		version = m.Version
+
+		result := &MigrationResult{Version: m.Version, Description: m.Description}
+		diffDocuments(snapshot, document, "", &result.Diff)
+		results = append(results, result)
+
+		d.l.Info(
+			"database: migration applied",
+			zap.Int("version", m.Version),
+			zap.String("description", m.Description),
+			zap.Int("changes", len(result.Diff)),
+		)
+	}
+
+	if len(results) == 0 {
+		return content, nil, nil
+	}
+
+	content, err = encodeDocument(document)
+	return content, results, err
+}
+
+// Migrate runs the pending migrations on the stored content and returns what each of them changed.
+// With dryRun, nothing is written. It is meant for the migrate command and closes the storage when done.
+func (d *Database) Migrate(dryRun bool) ([]*MigrationResult, error) {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	defer func() {
+		if err := d.storage.Close(); err != nil {
+			d.l.Error("database: cannot close storage", zap.Error(errors.WithStack(err)))
+		}
+	}()
+
+	if !d.storage.Exists() {
+		return nil, nil
+	}
+
+	content, err := d.storage.Load()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	content, results, err := d.migrate(content, !dryRun)
+	if err != nil || dryRun || len(results) == 0 {
+		return results, errors.WithStack(err)
+	}
+
+	if err = d.decode(content); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return results, errors.WithStack(d.Save())
+}
+
+func decodeDocument(content []byte) (Document, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var document Document
+	if err := decoder.Decode(&document); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if document == nil {
+		document = Document{}
+	}
+	return document, nil
+}
+
+func encodeDocument(document Document) ([]byte, error) {
+	content, err := json.Marshal(document)
+	return content, errors.WithStack(err)
+}
+
+// diffDocuments appends a line per added (+), removed (-) and changed (~) value between the given documents.
+func diffDocuments(before, after interface{}, path string, lines *[]string) {
+	child := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch b := before.(type) {
+	case map[string]interface{}:
+		if a, ok := after.(map[string]interface{}); ok {
+			keys := slices.Sorted(maps.Keys(b))
+			for _, key := range slices.Sorted(maps.Keys(a)) {
+				if _, found := b[key]; !found {
+					keys = append(keys, key)
+				}
+			}
+			for _, key := range keys {
+				diffDocuments(b[key], a[key], child(key), lines)
+			}
+			return
+		}
+	case []interface{}:
+		if a, ok := after.([]interface{}); ok {
+			for i := 0; i < max(len(a), len(b)); i++ {
+				var bi, ai interface{}
+				if i < len(b) {
+					bi = b[i]
+				}
+				if i < len(a) {
+					ai = a[i]
+				}
+				diffDocuments(bi, ai, child(fmt.Sprint(i)), lines)
+			}
+			return
+		}
+	}
+
+	switch {
+	case before == nil && after == nil:
+	case before == nil:
+		*lines = append(*lines, fmt.Sprintf("+ %s = %s", path, diffValue(after)))
+	case after == nil:
+		*lines = append(*lines, fmt.Sprintf("- %s = %s", path, diffValue(before)))
+	case diffValue(before) != diffValue(after):
+		*lines = append(*lines, fmt.Sprintf("~ %s = %s -> %s", path, diffValue(before), diffValue(after)))
+	}
+}
+
+func diffValue(value interface{}) string {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(j)
+}
+
+// migrateUsageResetAt sets the usage reset time of users created before it was tracked.
+func migrateUsageResetAt(document Document) error {
+	users, _ := document["users"].([]interface{})
+	for _, item := range users {
+		user, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, _ := user["usage_reset_at"].(json.Number); value == "" || value == "0" {
+			user["usage_reset_at"] = time.Now().UnixMilli()
+		}
+	}
+	return nil
+}
+
+// migrateResetStrategies fixes the usage reset times that the monthly reset stored in seconds,
+// and gives every user the reset policy of the settings, which used to apply to all of them, as its own strategy.
+func migrateResetStrategies(document Document) error {
+	strategy := ResetStrategyNone
+	if settings, ok := document["settings"].(map[string]interface{}); ok {
+		if policy, _ := settings["reset_policy"].(string); policy != "" {
+			strategy = policy
+		}
+	}
+
+	users, _ := document["users"].([]interface{})
+	for _, item := range users {
+		user, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, _ := user["usage_reset_at"].(json.Number); value != "" {
+			// Milliseconds have exceeded 1e12 since 2001, seconds will not before the year 33658.
+			if at, err := value.Int64(); err == nil && at > 0 && at < 1e12 {
+				user["usage_reset_at"] = json.Number(strconv.FormatInt(at*1000, 10))
+			}
+		}
+		if value, _ := user["reset_strategy"].(string); value == "" {
+			user["reset_strategy"] = strategy
+		}
+	}
+	return nil
+}
+
+// migrateUserCredentials generates the VLESS/VMess UUID and the Trojan password of users created before they were stored.
+func migrateUserCredentials(document Document) error {
+	users, _ := document["users"].([]interface{})
+	for _, item := range users {
+		user, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, _ := user["uuid"].(string); value == "" {
+			user["uuid"] = uuid.New().String()
+		}
+		if value, _ := user["trojan_password"].(string); value == "" {
+			user["trojan_password"] = utils.UUID()
+		}
+	}
+	return nil
+}
+
+// migrateLegacyNodes fills the protocol fields of nodes created before the multi-protocol redesign.
+// Those nodes served Shadowsocks on the global remote port, which is moved from the settings to each node.
+func migrateLegacyNodes(document Document) error {
+	settings, _ := document["settings"].(map[string]interface{})
+
+	port := json.Number("0")
+	if settings != nil {
+		if value, ok := settings["ss_remote_port"].(json.Number); ok {
+			port = value
+		}
+		for _, key := range []string{"ss_reverse_port", "ss_relay_port", "ss_direct_port", "ss_remote_port"} {
+			delete(settings, key)
+		}
+	}
+
+	nodes, _ := document["nodes"].([]interface{})
+	for _, item := range nodes {
+		node, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if protocol, _ := node["protocol"].(string); protocol != "" {
+			continue
+		}
+
+		host, _ := node["host"].(string)
+		serverIP := "0.0.0.0"
+		if net.ParseIP(host) != nil {
+			serverIP = host
+		}
+
+		node["core_type"] = "xray"
+		node["protocol"] = "shadowsocks"
+		node["server_name"] = fmt.Sprintf("Node %v", node["id"])
+		node["server_address"] = host
+		node["server_ip"] = serverIP
+		node["server_port"] = port.String()
+		node["encryption"] = config.ShadowsocksMethod
+		node["listening_ip"] = "0.0.0.0"
+		node["listening_port"] = port
+		node["network_settings"] = map[string]interface{}{"transport": "tcp"}
+		node["security"] = "none"
+		node["cert_mode"] = "none"
+		if _, found := node["fragment"]; !found {
+			node["fragment"] = false
+		}
+	}
+
+	return nil
+}
+
+// inboundKeys are the node fields that moved to the inbounds of the node.
+var inboundKeys = []string{
+	"protocol", "server_port", "encryption", "listening_ip", "listening_port",
+	"network_settings", "security", "security_settings", "cert_mode", "fragment", "fragment_value",
+}
+
+// migrateNodeInbounds moves the single inbound of the nodes, the protocol, network and security fields of the node,
+// to the inbound list of the node. The inbound is tagged with its protocol.
+// Nodes without a protocol, which were never configured, get no inbounds.
+func migrateNodeInbounds(document Document) error {
+	nodes, _ := document["nodes"].([]interface{})
+	for _, item := range nodes {
+		node, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, found := node["inbounds"]; found {
+			continue
+		}
+
+		inbounds := []interface{}{}
+		if protocol, _ := node["protocol"].(string); protocol != "" {
+			inbound := map[string]interface{}{"tag": protocol}
+			for _, key := range inboundKeys {
+				if value, found := node[key]; found {
+					inbound[key] = value
+				}
+			}
+			inbounds = append(inbounds, inbound)
+		}
+		for _, key := range inboundKeys {
+			delete(node, key)
+		}
+		node["inbounds"] = inbounds
+	}
+	return nil
+}
+
+// FormatMigrationResults renders migration results as plain text for the command line.
+func FormatMigrationResults(results []*MigrationResult) string {
+	var sb strings.Builder
+	for _, r := range results {
+		sb.WriteString(fmt.Sprintf("Migration %d: %s (%d changes)\n", r.Version, r.Description, len(r.Diff)))
+		for _, line := range r.Diff {
+			sb.WriteString("  " + line + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -1,5 +1,13 @@
 package database
 
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ebadidev/arch-manager/internal/utils"
+)
+
 // NodeStatus represents the status of a server (node).
 type NodeStatus string
 
@@ -8,6 +16,7 @@ const (
 	NodeStatusAvailable              = "available"
 	NodeStatusDirty                  = "dirty"
 	NodeStatusUnavailable            = "unavailable"
+	NodeStatusInvalid                = "invalid"
 )
 
 // Node represents a server (node) in the system.
@@ -24,25 +33,43 @@ type Node struct {
 	PushedAt   int64      `json:"pushed_at"`
 	PulledAt   int64      `json:"pulled_at"`
 
+	// ConfigError is the validation error of the last generated configuration, empty when it is valid
+	ConfigError string `json:"config_error"`
+
 	// Core Configuration
 	CoreType string `json:"core_type" validate:"required,oneof=xray"`
 
+	// Server Configuration
+	ServerName string `json:"server_name" validate:"required,max=128"`
+	ServerAddr string `json:"server_address" validate:"required,max=128"`
+	ServerIP   string `json:"server_ip" validate:"required,ip"`
+
+	// Network Configuration
+	SendThrough string `json:"send_through" validate:"omitempty,ip"`
+
+	// Advanced Settings
+	DNSSettings     DNSConfig     `json:"dns_settings"`
+	RoutingSettings RoutingConfig `json:"routing_settings"`
+
+	// Inbounds are the protocols that the node serves to the clients, on distinct ports
+	Inbounds []*Inbound `json:"inbounds" validate:"dive"`
+}
+
+// Inbound represents an inbound of a node, with its own protocol, transport, port and security.
+type Inbound struct {
+	// Tag tells the inbounds of the node apart, in the node configurations and the stats
+	Tag string `json:"tag" validate:"required,max=32"`
+
 	// Protocol Configuration
-	Protocol     string `json:"protocol" validate:"required,oneof=shadowsocks vmess vless trojan"`
-	ServerName   string `json:"server_name" validate:"required,max=128"`
-	ServerAddr   string `json:"server_address" validate:"required,max=128"`
-	ServerIP     string `json:"server_ip" validate:"required,ip"`
-	ServerPort   string `json:"server_port" validate:"required"`
-	Encryption   string `json:"encryption" validate:"required"`
+	Protocol   string `json:"protocol" validate:"required,oneof=shadowsocks vmess vless trojan"`
+	ServerPort string `json:"server_port" validate:"required"` // A port or a range of ports like "400:450"
+	Encryption string `json:"encryption" validate:"required"`
 
 	// Network Configuration
 	ListeningIP   string `json:"listening_ip" validate:"required,ip"`
 	ListeningPort int    `json:"listening_port" validate:"required,min=1,max=65536"`
-	SendThrough   string `json:"send_through" validate:"omitempty,ip"`
 
 	// Advanced Settings
-	DNSSettings     DNSConfig     `json:"dns_settings"`
-	RoutingSettings RoutingConfig `json:"routing_settings"`
 	NetworkSettings NetworkConfig `json:"network_settings"`
 
 	// Security Configuration
@@ -110,3 +137,143 @@ type RealityConfig struct {
 	SpiderX      string   `json:"spider_x"`
 	PublicKey    string   `json:"public_key"`
 }
+
+// HopPorts returns the other ports of the server port range of the inbound, which the inbound listens on besides its
+// listening port for the clients that hop ports, or nil when the server port is a single port.
+func (in *Inbound) HopPorts() []int {
+	r, err := utils.ParsePortRange(in.ServerPort)
+	if err != nil || r.Single() {
+		return nil
+	}
+
+	var ports []int
+	for _, port := range r.Ports() {
+		if port != in.ListeningPort {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// Hops returns copies of the inbound on its hop ports, tagged "<tag>:<port>", which the subscriptions advertise besides
+// the inbound so the clients move to another port of the range when one is blocked.
+func (in *Inbound) Hops() []*Inbound {
+	var hops []*Inbound
+	for _, port := range in.HopPorts() {
+		hop := *in
+		hop.Tag = fmt.Sprintf("%s:%d", in.Tag, port)
+		hop.ListeningPort = port
+		hops = append(hops, &hop)
+	}
+	return hops
+}
+
+// Redacted returns a copy of the node with the secrets replaced by Redacted.
+func (n *Node) Redacted() *Node {
+	node := *n
+	node.HttpToken = Redacted
+	node.Inbounds = make([]*Inbound, len(n.Inbounds))
+	for i, in := range n.Inbounds {
+		inbound := *in
+		if in.SecuritySettings.Reality != nil {
+			reality := *in.SecuritySettings.Reality
+			reality.PrivateKey = Redacted
+			inbound.SecuritySettings.Reality = &reality
+		}
+		node.Inbounds[i] = &inbound
+	}
+	return &node
+}
+
+// RestoreSecrets replaces the redacted secrets of the node inbounds with the secrets of the inbounds of the given stored
+// node having the same tags, as the API responses redact them.
+func (n *Node) RestoreSecrets(stored *Node) {
+	for _, in := range n.Inbounds {
+		r := in.SecuritySettings.Reality
+		if r == nil || r.PrivateKey != Redacted {
+			continue
+		}
+		if s := stored.FindInbound(in.Tag); s != nil && s.SecuritySettings.Reality != nil {
+			r.PrivateKey = s.SecuritySettings.Reality.PrivateKey
+		}
+	}
+}
+
+// FindInbound returns the inbound of the node with the given tag, or nil if there is none.
+func (n *Node) FindInbound(tag string) *Inbound {
+	for _, in := range n.Inbounds {
+		if in.Tag == tag {
+			return in
+		}
+	}
+	return nil
+}
+
+// InboundName returns the display name of the given inbound of the node, the server name of the node,
+// followed by the inbound tag when the node has more than one inbound, and by the port for the hops of the inbound.
+func (n *Node) InboundName(inbound *Inbound) string {
+	tag, port, hop := strings.Cut(inbound.Tag, ":")
+	name := n.ServerName
+	if len(n.Inbounds) > 1 {
+		name = fmt.Sprintf("%s (%s)", n.ServerName, tag)
+	}
+	if hop {
+		name += ":" + port
+	}
+	return name
+}
+
+// Nodes returns all the nodes.
+func (d *Database) Nodes() []*Node {
+	return d.Content.Nodes
+}
+
+// FindNode returns the node with the given id, or nil if there is none.
+func (d *Database) FindNode(id int) *Node {
+	for _, n := range d.Content.Nodes {
+		if n.Id == id {
+			return n
+		}
+	}
+	return nil
+}
+
+// FindNodeByAddress returns the node with the given host and HTTP port, or nil if there is none.
+func (d *Database) FindNodeByAddress(host string, httpPort int) *Node {
+	for _, n := range d.Content.Nodes {
+		if n.Host == host && n.HttpPort == httpPort {
+			return n
+		}
+	}
+	return nil
+}
+
+// AddNode appends the given node to the nodes.
+func (d *Database) AddNode(node *Node) {
+	d.Content.Nodes = append(d.Content.Nodes, node)
+}
+
+// ReplaceNode swaps the stored node having the same id with the given one and reports whether it existed.
+func (d *Database) ReplaceNode(node *Node) bool {
+	for i, n := range d.Content.Nodes {
+		if n.Id == node.Id {
+			d.Content.Nodes[i] = node
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteNode removes the node with the given id and reports whether it existed.
+func (d *Database) DeleteNode(id int) bool {
+	for i, n := range d.Content.Nodes {
+		if n.Id == id {
+			d.Content.Nodes = slices.Delete(d.Content.Nodes, i, i+1)
+			for _, u := range d.Content.Users {
+				delete(u.NodeUsageBytes, id)
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// recordLists are the content fields stored as one record per item instead of a single record.
+var recordLists = []string{"users", "nodes"}
+
+// legacyRecordLists are the record lists of older versions, joined back only while the storage still holds them.
+var legacyRecordLists = []string{"usage_histories"}
+
+// recordKey returns the record key of a list item, zero-padded so keys sort in id order.
+func recordKey(list string, id int) string {
+	return fmt.Sprintf("%s/%010d", list, id)
+}
+
+// splitRecords breaks a content document into records, so storages can persist only the parts that changed.
+// Top-level fields become one record each, while the items of the record lists become one record each.
+func splitRecords(document []byte) (map[string][]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(document, &fields); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	records := map[string][]byte{}
+	for key, value := range fields {
+		if !slices.Contains(recordLists, key) {
+			records[key] = value
+			continue
+		}
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(value, &items); err != nil {
+			return nil, errors.Wrapf(err, "cannot split %s", key)
+		}
+		for _, item := range items {
+			var head struct {
+				Id int `json:"id"`
+			}
+			if err := json.Unmarshal(item, &head); err != nil {
+				return nil, errors.Wrapf(err, "cannot split %s", key)
+			}
+			records[recordKey(key, head.Id)] = item
+		}
+	}
+
+	return records, nil
+}
+
+// joinRecords assembles records created by splitRecords back into a content document.
+func joinRecords(records map[string][]byte) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	lists := map[string][]json.RawMessage{}
+
+	for _, key := range slices.Sorted(maps.Keys(records)) {
+		if list, _, found := strings.Cut(key, "/"); found {
+			lists[list] = append(lists[list], records[key])
+		} else {
+			fields[key] = records[key]
+		}
+	}
+
+	for _, list := range recordLists {
+		items := lists[list]
+		if items == nil {
+			items = []json.RawMessage{}
+		}
+		value, err := json.Marshal(items)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		fields[list] = value
+	}
+	for _, list := range legacyRecordLists {
+		if items := lists[list]; items != nil {
+			value, err := json.Marshal(items)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			fields[list] = value
+		}
+	}
+
+	document, err := json.Marshal(fields)
+	return document, errors.WithStack(err)
+}
@@ -0,0 +1,275 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"go.uber.org/zap"
+)
+
+// MasterKeyEnv is the environment variable that provides the master key instead of the key file.
+const MasterKeyEnv = "ARCH_MANAGER_MASTER_KEY"
+
+// Redacted replaces secrets in API responses, and keeps the stored secret when it is sent back in a request.
+const Redacted = "********"
+
+const sealedPrefix = "enc:v1:"
+
+var ErrMasterKeyMismatch = errors.New("master key does not match the database")
+
+// Keyring holds the data key that encrypts the secrets, itself encrypted with the master key.
+type Keyring struct {
+	DataKey     string `json:"data_key"`
+	MasterKeyId string `json:"master_key_id"`
+}
+
+// MasterKeyId returns the fingerprint of the given master key, which tells keys apart without revealing them.
+func MasterKeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseMasterKey decodes a base64-encoded 32-byte master key.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid master key")
+	}
+	if len(key) != 32 {
+		return nil, errors.New("master key must be 32 bytes")
+	}
+	return key, nil
+}
+
+// MasterKeyFromEnv reports whether the master key is provided by the environment rather than the key file.
+func MasterKeyFromEnv() bool {
+	return os.Getenv(MasterKeyEnv) != ""
+}
+
+// loadMasterKey reads the master key from the environment or the key file.
+// The key file is created on the first run when neither is provided.
+func (d *Database) loadMasterKey() ([]byte, error) {
+	if encoded := os.Getenv(MasterKeyEnv); encoded != "" {
+		return ParseMasterKey(encoded)
+	}
+
+	content, err := os.ReadFile(d.c.Env.MasterKeyPath)
+	if err == nil {
+		return ParseMasterKey(string(content))
+	} else if !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+
+	encoded, err := utils.Key32()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = utils.WriteFileAtomic(d.c.Env.MasterKeyPath, []byte(encoded), 0600); err != nil {
+		return nil, errors.Wrap(err, "cannot create master key file")
+	}
+	d.l.Info("database: master key file created", zap.String("file", d.c.Env.MasterKeyPath))
+
+	return ParseMasterKey(encoded)
+}
+
+// pendingMasterKey returns the key left by a rotation that stopped before it replaced the key file.
+func (d *Database) pendingMasterKey() []byte {
+	content, err := os.ReadFile(d.c.Env.MasterKeyPath + ".new")
+	if err != nil {
+		return nil
+	}
+	key, err := ParseMasterKey(string(content))
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// openSecrets unwraps the data key of the loaded content and decrypts its secrets in place.
+// Content without a keyring has plaintext secrets, they are encrypted on the next save.
+func (d *Database) openSecrets() error {
+	d.dataKey, d.keyring, d.sealed = nil, nil, map[string]string{}
+
+	keyring := d.Content.Keyring
+	if keyring == nil {
+		return nil
+	}
+
+	if d.masterKey == nil {
+		key, err := d.loadMasterKey()
+		if err != nil {
+			return err
+		}
+		d.masterKey = key
+	}
+
+	if keyring.MasterKeyId != MasterKeyId(d.masterKey) {
+		pending := d.pendingMasterKey()
+		if pending == nil || keyring.MasterKeyId != MasterKeyId(pending) || MasterKeyFromEnv() {
+			return errors.Wrapf(ErrMasterKeyMismatch, "key %s, database key %s", MasterKeyId(d.masterKey), keyring.MasterKeyId)
+		}
+		// A rotation saved the database but did not replace the key file.
+		if err := os.Rename(d.c.Env.MasterKeyPath+".new", d.c.Env.MasterKeyPath); err != nil {
+			return errors.WithStack(err)
+		}
+		d.masterKey = pending
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(keyring.DataKey)
+	if err != nil {
+		return errors.Wrap(err, "invalid data key")
+	}
+	dataKey, err := utils.Decrypt(d.masterKey, wrapped)
+	if err != nil {
+		return errors.Wrap(err, "cannot decrypt data key")
+	}
+	d.dataKey, d.keyring = dataKey, keyring
+
+	var failed error
+	open := func(value *string) {
+		if !strings.HasPrefix(*value, sealedPrefix) {
+			return
+		}
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(*value, sealedPrefix))
+		if err == nil {
+			var plain []byte
+			if plain, err = utils.Decrypt(d.dataKey, sealed); err == nil {
+				d.sealed[string(plain)] = *value
+				*value = string(plain)
+				return
+			}
+		}
+		failed = errors.Wrap(err, "cannot decrypt secret")
+	}
+
+	forEachSecret(d.Content, open)
+	return failed
+}
+
+// sealContent returns a copy of the content with its secrets encrypted, ready to be persisted.
+// Ciphertexts are cached, so unchanged secrets keep the same ciphertext and records do not change on each save.
+func (d *Database) sealContent() (*Content, error) {
+	if d.masterKey == nil {
+		key, err := d.loadMasterKey()
+		if err != nil {
+			return nil, err
+		}
+		d.masterKey = key
+	}
+	if d.dataKey == nil {
+		d.dataKey = make([]byte, 32)
+		if _, err := rand.Read(d.dataKey); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		d.keyring, d.sealed = nil, map[string]string{}
+	}
+	if d.keyring == nil {
+		wrapped, err := utils.Encrypt(d.masterKey, d.dataKey)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		d.keyring = &Keyring{DataKey: base64.StdEncoding.EncodeToString(wrapped), MasterKeyId: MasterKeyId(d.masterKey)}
+	}
+	d.Content.Keyring = d.keyring
+
+	content := *d.Content
+	settings := *content.Settings
+	content.Settings = &settings
+	content.Users = make([]*User, len(d.Content.Users))
+	for i, u := range d.Content.Users {
+		user := *u
+		user.Retired = make([]*RetiredCredential, len(u.Retired))
+		for j, c := range u.Retired {
+			retired := *c
+			user.Retired[j] = &retired
+		}
+		content.Users[i] = &user
+	}
+	content.Nodes = make([]*Node, len(d.Content.Nodes))
+	for i, n := range d.Content.Nodes {
+		node := *n
+		node.Inbounds = make([]*Inbound, len(n.Inbounds))
+		for j, in := range n.Inbounds {
+			inbound := *in
+			if in.SecuritySettings.Reality != nil {
+				reality := *in.SecuritySettings.Reality
+				inbound.SecuritySettings.Reality = &reality
+			}
+			node.Inbounds[j] = &inbound
+		}
+		content.Nodes[i] = &node
+	}
+
+	var failed error
+	cache := map[string]string{}
+	seal := func(value *string) {
+		if *value == "" {
+			return
+		}
+		sealed, found := d.sealed[*value]
+		if !found {
+			ciphertext, err := utils.Encrypt(d.dataKey, []byte(*value))
+			if err != nil {
+				failed = errors.WithStack(err)
+				return
+			}
+			sealed = sealedPrefix + base64.StdEncoding.EncodeToString(ciphertext)
+		}
+		cache[*value] = sealed
+		*value = sealed
+	}
+
+	forEachSecret(&content, seal)
+	d.sealed = cache
+	return &content, failed
+}
+
+// forEachSecret calls the given function with every secret field of the content.
+func forEachSecret(content *Content, f func(value *string)) {
+	f(&content.Settings.AdminPassword)
+	for _, u := range content.Users {
+		f(&u.ShadowsocksPassword)
+		f(&u.UUID)
+		f(&u.TrojanPassword)
+		for _, c := range u.Retired {
+			f(&c.Value)
+		}
+	}
+	for _, n := range content.Nodes {
+		f(&n.HttpToken)
+		for _, in := range n.Inbounds {
+			if in.SecuritySettings.Reality != nil {
+				f(&in.SecuritySettings.Reality.PrivateKey)
+			}
+		}
+	}
+}
+
+// RotateMasterKey re-encrypts the database with the given master key and a new data key.
+// The caller replaces the key file or the environment variable once it succeeds.
+func (d *Database) RotateMasterKey(key []byte) error {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	previous, previousData, previousKeyring, previousSealed := d.masterKey, d.dataKey, d.keyring, d.sealed
+	d.masterKey, d.dataKey, d.keyring, d.sealed = key, nil, nil, nil
+
+	if err := d.Save(); err != nil {
+		d.masterKey, d.dataKey, d.keyring, d.sealed = previous, previousData, previousKeyring, previousSealed
+		d.Content.Keyring = previousKeyring
+		return err
+	}
+
+	d.l.Info(
+		"database: master key rotated",
+		zap.String("from", MasterKeyId(previous)),
+		zap.String("to", MasterKeyId(key)),
+	)
+	return nil
+}
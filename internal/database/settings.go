@@ -1,12 +1,30 @@
 package database
 
+import "time"
+
 type Settings struct {
 	AdminPassword     string            `json:"admin_password" validate:"required,min=8,max=32"`
 	Host              string            `json:"host" validate:"required,max=128"`
 	TrafficRatio      float64           `json:"traffic_ratio" validate:"min=1,max=1024"`
 	SingetServer      string            `json:"singet_server" validate:"omitempty,url"`
-	ResetPolicy       string            `json:"reset_policy" validate:"omitempty,oneof=monthly"`
+	ResetPolicy       string            `json:"reset_policy" validate:"omitempty,oneof=none daily weekly monthly yearly"`
+	Timezone          string            `json:"timezone" validate:"omitempty,timezone"`
 	EncryptionOptions EncryptionOptions `json:"encryption_options"`
+	SingBox           SingBoxTemplates  `json:"sing_box"`
+	XrayClient        XrayClientPresets `json:"xray_client"`
+}
+
+// SingBoxTemplates holds the sections of the sing-box subscription profiles that are not generated from the nodes.
+// They are copied as is, and the built-in ones are used when they are empty.
+type SingBoxTemplates struct {
+	DNS   map[string]interface{} `json:"dns"`
+	Route map[string]interface{} `json:"route"`
+}
+
+// XrayClientPresets holds the routing rules of the Xray client configurations of the subscriptions.
+// The outbound tag of a rule is "proxy", "direct" or "block", and the built-in rules are used when there are none.
+type XrayClientPresets struct {
+	Rules []RoutingRule `json:"rules"`
 }
 
 type EncryptionOptions struct {
@@ -15,3 +33,40 @@ type EncryptionOptions struct {
 	Trojan []string `json:"trojan"`
 	SS     []string `json:"ss"`
 }
+
+// Settings returns the application settings.
+func (d *Database) Settings() *Settings {
+	return d.Content.Settings
+}
+
+// UpdateSettings replaces the application settings.
+func (d *Database) UpdateSettings(settings *Settings) {
+	d.Content.Settings = settings
+}
+
+// Redacted returns a copy of the settings with the secrets replaced by Redacted.
+func (s *Settings) Redacted() *Settings {
+	settings := *s
+	settings.AdminPassword = Redacted
+	return &settings
+}
+
+// Location returns the time zone of the usage resets, the local time zone of the server by default.
+func (s *Settings) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// DefaultResetStrategy returns the reset strategy of new users without their own.
+func (s *Settings) DefaultResetStrategy() string {
+	if s.ResetPolicy == "" {
+		return ResetStrategyNone
+	}
+	return s.ResetPolicy
+}
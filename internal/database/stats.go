@@ -5,3 +5,8 @@ type Stats struct {
 	TotalUsage        float64 `json:"total_usage" validate:"min=0"`
 	TotalUsageBytes   int64   `json:"total_usage_bytes" validate:"min=0"`
 }
+
+// Stats returns the global usage statistics.
+func (d *Database) Stats() *Stats {
+	return d.Content.Stats
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"github.com/ebadidev/arch-manager/internal/config"
+)
+
+// Storage persists the database content.
+// Implementations hand the content back as a JSON document, so it is decoded the same way whatever the medium is.
+type Storage interface {
+	// Exists reports whether the storage holds any content yet.
+	Exists() bool
+	// Load returns the stored content as a JSON document.
+	Load() ([]byte, error)
+	// Save persists the given content.
+	Save(content *Content) error
+	// Reset moves the stored content aside, so a fresh one can be saved when it cannot be loaded.
+	Reset() error
+	// Close releases the resources held by the storage.
+	Close() error
+}
+
+// NewStorage creates the storage selected by the configured database driver.
+func NewStorage(c *config.Config) Storage {
+	switch c.Database.Driver {
+	case "bolt":
+		return NewBoltStorage(c.Env.DatabaseBoltPath)
+	default:
+		return NewJsonStorage(c.Env.DatabasePath, c.Env.DatabaseJournalPath)
+	}
+}
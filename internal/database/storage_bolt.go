@@ -0,0 +1,142 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("content")
+
+// BoltStorage keeps the content in an embedded bbolt database, one key per record.
+// It remembers the last persisted records and only writes the ones that changed since.
+type BoltStorage struct {
+	path    string
+	db      *bolt.DB
+	records map[string][]byte
+}
+
+func (s *BoltStorage) open() error {
+	if s.db != nil {
+		return nil
+	}
+
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.db = db
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	return errors.WithStack(err)
+}
+
+func (s *BoltStorage) Exists() bool {
+	return utils.FileExist(s.path)
+}
+
+func (s *BoltStorage) Load() ([]byte, error) {
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	records := map[string][]byte{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			records[string(k)] = slices.Clone(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	s.records = records
+	return joinRecords(records)
+}
+
+func (s *BoltStorage) Save(content *Content) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	document, err := json.Marshal(content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	records, err := splitRecords(document)
+	if err != nil {
+		return err
+	}
+
+	var changed, removed []string
+	for key, value := range records {
+		if !bytes.Equal(s.records[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range s.records {
+		if _, found := records[key]; !found {
+			removed = append(removed, key)
+		}
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, key := range changed {
+			if err := b.Put([]byte(key), records[key]); err != nil {
+				return err
+			}
+		}
+		for _, key := range removed {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.records = records
+	return nil
+}
+
+func (s *BoltStorage) Reset() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path, fmt.Sprintf("%s.corrupt-%d", s.path, time.Now().Unix())); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	s.records = nil
+	return nil
+}
+
+func (s *BoltStorage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return errors.WithStack(err)
+}
+
+func NewBoltStorage(path string) *BoltStorage {
+	return &BoltStorage{path: path}
+}
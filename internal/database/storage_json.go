@@ -0,0 +1,227 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/utils"
+)
+
+// generationField is the field of the snapshot document that holds the generation of the snapshot.
+const generationField = "journal_generation"
+
+// journalEntry holds the records changed by a single save, a "null" record means it was deleted.
+// The generation is the one of the snapshot the entry was appended to.
+type journalEntry struct {
+	Generation int                        `json:"generation,omitempty"`
+	Records    map[string]json.RawMessage `json:"records"`
+}
+
+// JsonStorage keeps the content in a JSON snapshot file plus an append-only journal.
+// Saves append the changed records to the journal, and the snapshot is rewritten atomically
+// once the journal grows larger than the snapshot itself.
+// Each snapshot has a new generation, so the entries of a journal that outlived the snapshot it was appended to
+// (when the process dies between the two) are not replayed over the newer snapshot.
+type JsonStorage struct {
+	path         string
+	journalPath  string
+	records      map[string][]byte
+	generation   int
+	snapshotSize int
+	journalSize  int
+	compact      bool
+}
+
+func (s *JsonStorage) Exists() bool {
+	return utils.FileExist(s.path)
+}
+
+func (s *JsonStorage) Load() ([]byte, error) {
+	snapshot, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	records, err := splitRecords(snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read snapshot")
+	}
+
+	// Snapshots of older versions have no generation, as the entries of their journals.
+	generation := 0
+	if value, found := records[generationField]; found {
+		if err = json.Unmarshal(value, &generation); err != nil {
+			return nil, errors.Wrap(err, "cannot read snapshot generation")
+		}
+		delete(records, generationField)
+	}
+	s.generation = generation
+
+	if err = s.replay(records); err != nil {
+		return nil, errors.Wrap(err, "cannot replay journal")
+	}
+
+	s.records = records
+	s.snapshotSize = len(snapshot)
+	s.journalSize = 0
+	// The journal may end with a torn entry, so it must not be appended to before a fresh snapshot.
+	s.compact = utils.FileExist(s.journalPath)
+
+	return joinRecords(records)
+}
+
+// replay applies the journal entries of the generation of the snapshot on the given records.
+// It stops at the first unreadable entry, which is the one being written when the process died.
+func (s *JsonStorage) replay(records map[string][]byte) error {
+	file, err := os.Open(s.journalPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A line without the trailing newline has not been completely written.
+			return nil
+		}
+
+		var entry journalEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			return nil
+		}
+		if entry.Generation < s.generation {
+			continue
+		}
+		for key, value := range entry.Records {
+			if bytes.Equal(value, []byte("null")) {
+				delete(records, key)
+			} else {
+				records[key] = value
+			}
+		}
+	}
+}
+
+func (s *JsonStorage) Save(content *Content) error {
+	document, err := json.Marshal(content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	records, err := splitRecords(document)
+	if err != nil {
+		return err
+	}
+
+	if s.records == nil || s.compact || s.journalSize > s.snapshotSize {
+		return s.snapshot(records)
+	}
+
+	entry := journalEntry{Generation: s.generation, Records: map[string]json.RawMessage{}}
+	for key, value := range records {
+		if !bytes.Equal(s.records[key], value) {
+			entry.Records[key] = value
+		}
+	}
+	for key := range s.records {
+		if _, found := records[key]; !found {
+			entry.Records[key] = json.RawMessage("null")
+		}
+	}
+	if len(entry.Records) == 0 {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err = s.append(append(line, '\n')); err != nil {
+		return err
+	}
+
+	s.records = records
+	s.journalSize += len(line) + 1
+	return nil
+}
+
+// append writes the given line to the end of the journal and syncs it to the disk.
+func (s *JsonStorage) append(line []byte) error {
+	file, err := os.OpenFile(s.journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err = file.Write(line); err != nil {
+		_ = file.Close()
+		return errors.WithStack(err)
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(file.Close())
+}
+
+// snapshot atomically rewrites the snapshot file of the next generation and discards the journal it covers.
+func (s *JsonStorage) snapshot(records map[string][]byte) error {
+	generation, err := json.Marshal(s.generation + 1)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	stamped := maps.Clone(records)
+	stamped[generationField] = generation
+	document, err := joinRecords(stamped)
+	if err != nil {
+		return err
+	}
+
+	if err = utils.WriteFileAtomic(s.path, document, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+	s.generation++
+	if err = os.Remove(s.journalPath); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	s.records = records
+	s.snapshotSize = len(document)
+	s.journalSize = 0
+	s.compact = false
+	return nil
+}
+
+func (s *JsonStorage) Reset() error {
+	suffix := fmt.Sprintf(".corrupt-%d", time.Now().Unix())
+	for _, path := range []string{s.path, s.journalPath} {
+		if err := os.Rename(path, path+suffix); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+
+	s.records = nil
+	return nil
+}
+
+func (s *JsonStorage) Close() error {
+	if s.records == nil || (s.journalSize == 0 && !s.compact) {
+		return nil
+	}
+	return s.snapshot(s.records)
+}
+
+func NewJsonStorage(path, journalPath string) *JsonStorage {
+	return &JsonStorage{path: path, journalPath: journalPath}
+}
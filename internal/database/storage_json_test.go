@@ -0,0 +1,151 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestJsonStorage(t *testing.T) *JsonStorage {
+	t.Helper()
+	directory := t.TempDir()
+	return NewJsonStorage(filepath.Join(directory, "app.json"), filepath.Join(directory, "app.journal"))
+}
+
+func testContent(host string, users ...int) *Content {
+	content := newContent()
+	content.Settings.Host = host
+	for _, id := range users {
+		content.Users = append(content.Users, &User{Id: id, Name: host})
+	}
+	return content
+}
+
+func loadContent(t *testing.T, s *JsonStorage) *Content {
+	t.Helper()
+	document, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := &Content{}
+	if err = json.Unmarshal(document, content); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestJsonStorageReplay(t *testing.T) {
+	s := newTestJsonStorage(t)
+	if err := s.Save(testContent("first", 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(testContent("second", 1, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Exists() || s.journalSize == 0 {
+		t.Fatal("got no journal entry for the second save")
+	}
+
+	// A new storage stands for a restart, which replays the journal over the snapshot
+	content := loadContent(t, NewJsonStorage(s.path, s.journalPath))
+	if content.Settings.Host != "second" {
+		t.Errorf("got the host %s, want second", content.Settings.Host)
+	}
+	if len(content.Users) != 2 || content.Users[0].Id != 1 || content.Users[1].Id != 3 {
+		t.Errorf("got %d users, want the users 1 and 3", len(content.Users))
+	}
+}
+
+func TestJsonStorageTornEntry(t *testing.T) {
+	s := newTestJsonStorage(t)
+	if err := s.Save(testContent("first", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(testContent("second", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.append([]byte(`{"records":{"settings":`)); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewJsonStorage(s.path, s.journalPath)
+	if content := loadContent(t, reloaded); content.Settings.Host != "second" {
+		t.Errorf("got the host %s, want second", content.Settings.Host)
+	}
+	if !reloaded.compact {
+		t.Error("got a journal with a torn entry to append to")
+	}
+}
+
+func TestJsonStorageCrashBeforeJournalRemoval(t *testing.T) {
+	s := newTestJsonStorage(t)
+	if err := s.Save(testContent("first", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(testContent("second", 1)); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := os.ReadFile(s.journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The process dies after the new snapshot is renamed into place and before the journal is removed
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save(testContent("third", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(s.journalPath, journal, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewJsonStorage(s.path, s.journalPath)
+	if content := loadContent(t, reloaded); content.Settings.Host != "third" {
+		t.Errorf("got the host %s, want third: the journal of an older snapshot was replayed", content.Settings.Host)
+	}
+
+	// The next save writes a new snapshot, which discards the stale journal
+	if err = reloaded.Save(testContent("fourth", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(s.journalPath); !os.IsNotExist(err) {
+		t.Errorf("got the stale journal after a save: %v", err)
+	}
+	if content := loadContent(t, NewJsonStorage(s.path, s.journalPath)); content.Settings.Host != "fourth" {
+		t.Errorf("got the host %s, want fourth", content.Settings.Host)
+	}
+}
+
+func TestJsonStorageUnstampedSnapshot(t *testing.T) {
+	s := newTestJsonStorage(t)
+
+	// Snapshots and journals of older versions have no generation
+	document, err := json.Marshal(testContent("first", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(s.path, document, 0600); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := json.Marshal(testContent("second").Settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := json.Marshal(map[string]interface{}{"records": map[string]json.RawMessage{"settings": settings}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.append(append(entry, '\n')); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := loadContent(t, s); content.Settings.Host != "second" {
+		t.Errorf("got the host %s, want second", content.Settings.Host)
+	}
+}
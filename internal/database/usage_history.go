@@ -0,0 +1,200 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/utils"
+)
+
+const (
+	UsageStepMinute = "minute"
+	UsageStepHour   = "hour"
+	UsageStepDay    = "day"
+)
+
+// usageFlushInterval is how often the usage histories are written to their file.
+// They change every minute, so they are kept apart from the content and flushed less often.
+const usageFlushInterval = 10 * time.Minute
+
+// UsagePoint is the traffic in bytes of the bucket starting at T (unix milliseconds).
+type UsagePoint struct {
+	T int64 `json:"t"`
+	B int64 `json:"b"`
+}
+
+// UsageHistory is the traffic time series of the user with the same id.
+// Traffic is added to the minute, hour and day buckets at once, and each series keeps its own retention.
+type UsageHistory struct {
+	Id      int          `json:"id"`
+	Minutes []UsagePoint `json:"minutes"`
+	Hours   []UsagePoint `json:"hours"`
+	Days    []UsagePoint `json:"days"`
+}
+
+// UsageHistories returns the usage histories of all the users.
+func (d *Database) UsageHistories() []*UsageHistory {
+	return d.usageHistories
+}
+
+// loadUsageHistories reads the usage histories from their file, if there is one yet.
+func (d *Database) loadUsageHistories() error {
+	content, err := os.ReadFile(d.c.Env.DatabaseUsagePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var histories []*UsageHistory
+	if err = json.Unmarshal(content, &histories); err != nil {
+		return errors.Wrap(err, "cannot read usage histories")
+	}
+	d.usageHistories = histories
+	return nil
+}
+
+// adoptUsageHistories moves the usage histories stored in the given content document by older versions
+// to their own file, unless it exists already. The content drops them on its next save.
+func (d *Database) adoptUsageHistories(content []byte) error {
+	var legacy struct {
+		UsageHistories []*UsageHistory `json:"usage_histories"`
+	}
+	if err := json.Unmarshal(content, &legacy); err != nil {
+		return errors.WithStack(err)
+	}
+	if len(legacy.UsageHistories) == 0 || utils.FileExist(d.c.Env.DatabaseUsagePath) {
+		return nil
+	}
+
+	d.usageHistories = legacy.UsageHistories
+	return d.saveUsageHistories()
+}
+
+// saveUsageHistories writes the usage histories to their file.
+func (d *Database) saveUsageHistories() error {
+	content, err := json.Marshal(d.usageHistories)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err = utils.WriteFileAtomic(d.c.Env.DatabaseUsagePath, content, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+
+	d.usageFlushedAt = time.Now()
+	return nil
+}
+
+// FlushUsageHistories writes the usage histories to their file if the last write is older than the flush interval.
+// The traffic recorded since is lost if the process dies, while the usage of the users is saved with the content.
+func (d *Database) FlushUsageHistories(now time.Time) error {
+	if now.Sub(d.usageFlushedAt) < usageFlushInterval {
+		return nil
+	}
+	return d.saveUsageHistories()
+}
+
+// FindUsageHistory returns the usage history of the user with the given id, or nil if there is none.
+func (d *Database) FindUsageHistory(userId int) *UsageHistory {
+	for _, h := range d.usageHistories {
+		if h.Id == userId {
+			return h
+		}
+	}
+	return nil
+}
+
+// RecordUsage adds the traffic of the given user at the given time to the usage history, and drops expired buckets.
+func (d *Database) RecordUsage(userId int, bytes int64, at time.Time) {
+	if bytes <= 0 {
+		return
+	}
+
+	h := d.FindUsageHistory(userId)
+	if h == nil {
+		h = &UsageHistory{Id: userId, Minutes: []UsagePoint{}, Hours: []UsagePoint{}, Days: []UsagePoint{}}
+		d.usageHistories = append(d.usageHistories, h)
+		slices.SortFunc(d.usageHistories, func(a, b *UsageHistory) int {
+			return a.Id - b.Id
+		})
+	}
+
+	r := d.c.UsageHistory
+	h.Minutes = addUsage(h.Minutes, UsageBucket(UsageStepMinute, at), bytes, at.Add(-time.Duration(r.Minutes)*time.Minute))
+	h.Hours = addUsage(h.Hours, UsageBucket(UsageStepHour, at), bytes, at.Add(-time.Duration(r.Hours)*time.Hour))
+	h.Days = addUsage(h.Days, UsageBucket(UsageStepDay, at), bytes, at.AddDate(0, 0, -r.Days))
+}
+
+// deleteUsageHistories removes the usage histories of the users that no longer exist.
+func (d *Database) deleteUsageHistories() {
+	d.usageHistories = slices.DeleteFunc(d.usageHistories, func(h *UsageHistory) bool {
+		return d.FindUser(h.Id) == nil
+	})
+}
+
+// UsageSeries returns the traffic of the given user in buckets of the given step, from the bucket of `from` until `to`.
+// Buckets without traffic are included with zero bytes, so the series can be charted as is.
+func (d *Database) UsageSeries(userId int, step string, from, to time.Time) []UsagePoint {
+	var points []UsagePoint
+	if h := d.FindUsageHistory(userId); h != nil {
+		switch step {
+		case UsageStepMinute:
+			points = h.Minutes
+		case UsageStepHour:
+			points = h.Hours
+		default:
+			points = h.Days
+		}
+	}
+
+	recorded := map[int64]int64{}
+	for _, p := range points {
+		recorded[p.T] = p.B
+	}
+
+	series := []UsagePoint{}
+	for t := UsageBucket(step, from); t.Before(to); t = nextUsageBucket(step, t) {
+		series = append(series, UsagePoint{T: t.UnixMilli(), B: recorded[t.UnixMilli()]})
+	}
+	return series
+}
+
+// UsageBucket returns the start of the bucket of the given step containing the given time.
+func UsageBucket(step string, at time.Time) time.Time {
+	switch step {
+	case UsageStepMinute:
+		return at.Truncate(time.Minute)
+	case UsageStepHour:
+		return at.Truncate(time.Hour)
+	default:
+		return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	}
+}
+
+func nextUsageBucket(step string, bucket time.Time) time.Time {
+	switch step {
+	case UsageStepMinute:
+		return bucket.Add(time.Minute)
+	case UsageStepHour:
+		return bucket.Add(time.Hour)
+	default:
+		return bucket.AddDate(0, 0, 1)
+	}
+}
+
+// addUsage adds the bytes to the point of the given bucket, and drops the points of buckets before expiry.
+func addUsage(points []UsagePoint, bucket time.Time, bytes int64, expiry time.Time) []UsagePoint {
+	t := bucket.UnixMilli()
+	if n := len(points); n > 0 && points[n-1].T == t {
+		points[n-1].B += bytes
+	} else {
+		points = append(points, UsagePoint{T: t, B: bytes})
+	}
+
+	return slices.DeleteFunc(points, func(p UsagePoint) bool {
+		return p.T < expiry.UnixMilli()
+	})
+}
@@ -0,0 +1,76 @@
+package database
+
+import "time"
+
+const (
+	ResetStrategyNone    = "none"
+	ResetStrategyDaily   = "daily"
+	ResetStrategyWeekly  = "weekly"
+	ResetStrategyMonthly = "monthly"
+	ResetStrategyYearly  = "yearly"
+)
+
+// NextUsageReset returns the first reset of the user usage after its last reset, or false if it is never reset.
+// Resets happen at midnight in the given location: every day, or on the weekday, the day of the month or the date
+// of the year the user was created. Days missing from shorter months fall back to their last day.
+// Users without a creation time reset on Mondays, on the first of the month and on January 1st.
+func (u *User) NextUsageReset(loc *time.Location) (time.Time, bool) {
+	last := time.UnixMilli(u.UsageResetAt).In(loc)
+	// January 1st, 2001 is a Monday.
+	anchor := time.Date(2001, time.January, 1, 0, 0, 0, 0, loc)
+	if u.CreatedAt > 0 {
+		anchor = time.UnixMilli(u.CreatedAt).In(loc)
+	}
+
+	var next time.Time
+	switch u.ResetStrategy {
+	case ResetStrategyDaily:
+		return startOfDay(last.Year(), last.Month(), last.Day()+1, loc), true
+	case ResetStrategyWeekly:
+		days := (int(anchor.Weekday()) - int(last.Weekday()) + 7) % 7
+		next = startOfDay(last.Year(), last.Month(), last.Day()+days, loc)
+		if !next.After(last) {
+			next = startOfDay(last.Year(), last.Month(), last.Day()+days+7, loc)
+		}
+	case ResetStrategyMonthly:
+		next = anniversary(last.Year(), last.Month(), anchor.Day(), loc)
+		if !next.After(last) {
+			next = anniversary(last.Year(), last.Month()+1, anchor.Day(), loc)
+		}
+	case ResetStrategyYearly:
+		next = anniversary(last.Year(), anchor.Month(), anchor.Day(), loc)
+		if !next.After(last) {
+			next = anniversary(last.Year()+1, anchor.Month(), anchor.Day(), loc)
+		}
+	default:
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// UsageResetDue reports whether the usage of the user has a reset due at the given time.
+func (u *User) UsageResetDue(now time.Time, loc *time.Location) bool {
+	next, scheduled := u.NextUsageReset(loc)
+	return scheduled && !now.Before(next)
+}
+
+// anniversary returns the start of the given day of the month, or of the last day of shorter months.
+func anniversary(year int, month time.Month, day int, loc *time.Location) time.Time {
+	if last := time.Date(year, month+1, 0, 12, 0, 0, 0, loc).Day(); day > last {
+		day = last
+	}
+	return startOfDay(year, month, day, loc)
+}
+
+// startOfDay returns the midnight of the given day, normalized like time.Date. On the days daylight saving time
+// starts at midnight, there is no midnight and the day starts at the end of the gap, instead of the day before.
+func startOfDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	noon := time.Date(year, month, day, 12, 0, 0, 0, loc)
+	if midnight.Day() == noon.Day() {
+		return midnight
+	}
+	_, before := midnight.Zone()
+	_, after := noon.Zone()
+	return midnight.Add(time.Duration(after-before) * time.Second)
+}
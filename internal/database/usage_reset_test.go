@@ -0,0 +1,241 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("cannot load location %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestNextUsageReset(t *testing.T) {
+	utc := time.UTC
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	santiago := mustLoadLocation(t, "America/Santiago")
+	tehran := mustLoadLocation(t, "Asia/Tehran")
+
+	date := func(loc *time.Location, year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		loc      *time.Location
+		created  time.Time
+		last     time.Time
+		want     time.Time
+	}{
+		{
+			name:     "daily",
+			strategy: ResetStrategyDaily,
+			loc:      utc,
+			last:     date(utc, 2024, time.May, 10, 13, 0),
+			want:     date(utc, 2024, time.May, 11, 0, 0),
+		},
+		{
+			name:     "daily right after a reset",
+			strategy: ResetStrategyDaily,
+			loc:      utc,
+			last:     date(utc, 2024, time.May, 11, 0, 0),
+			want:     date(utc, 2024, time.May, 12, 0, 0),
+		},
+		{
+			name:     "weekly on the weekday of the creation",
+			strategy: ResetStrategyWeekly,
+			loc:      utc,
+			created:  date(utc, 2024, time.May, 1, 15, 0),
+			last:     date(utc, 2024, time.May, 10, 9, 0),
+			want:     date(utc, 2024, time.May, 15, 0, 0),
+		},
+		{
+			name:     "weekly right after a reset",
+			strategy: ResetStrategyWeekly,
+			loc:      utc,
+			created:  date(utc, 2024, time.May, 1, 15, 0),
+			last:     date(utc, 2024, time.May, 15, 0, 1),
+			want:     date(utc, 2024, time.May, 22, 0, 0),
+		},
+		{
+			name:     "monthly on the 31st falls back to February 29th",
+			strategy: ResetStrategyMonthly,
+			loc:      utc,
+			created:  date(utc, 2024, time.January, 31, 8, 0),
+			last:     date(utc, 2024, time.January, 31, 0, 0),
+			want:     date(utc, 2024, time.February, 29, 0, 0),
+		},
+		{
+			name:     "monthly on the 31st falls back to February 28th",
+			strategy: ResetStrategyMonthly,
+			loc:      utc,
+			created:  date(utc, 2023, time.January, 31, 8, 0),
+			last:     date(utc, 2023, time.January, 31, 0, 0),
+			want:     date(utc, 2023, time.February, 28, 0, 0),
+		},
+		{
+			name:     "monthly on the 31st gets back to the 31st after February",
+			strategy: ResetStrategyMonthly,
+			loc:      utc,
+			created:  date(utc, 2023, time.January, 31, 8, 0),
+			last:     date(utc, 2023, time.February, 28, 0, 0),
+			want:     date(utc, 2023, time.March, 31, 0, 0),
+		},
+		{
+			name:     "monthly on the 31st skips to the 30th of shorter months",
+			strategy: ResetStrategyMonthly,
+			loc:      utc,
+			created:  date(utc, 2023, time.January, 31, 8, 0),
+			last:     date(utc, 2023, time.March, 31, 0, 0),
+			want:     date(utc, 2023, time.April, 30, 0, 0),
+		},
+		{
+			name:     "yearly on February 29th falls back to February 28th",
+			strategy: ResetStrategyYearly,
+			loc:      utc,
+			created:  date(utc, 2024, time.February, 29, 12, 0),
+			last:     date(utc, 2024, time.February, 29, 12, 0),
+			want:     date(utc, 2025, time.February, 28, 0, 0),
+		},
+		{
+			name:     "yearly on February 29th gets back to it in leap years",
+			strategy: ResetStrategyYearly,
+			loc:      utc,
+			created:  date(utc, 2024, time.February, 29, 12, 0),
+			last:     date(utc, 2027, time.February, 28, 0, 0),
+			want:     date(utc, 2028, time.February, 29, 0, 0),
+		},
+		{
+			name:     "weekly without a creation time resets on Mondays",
+			strategy: ResetStrategyWeekly,
+			loc:      utc,
+			last:     date(utc, 2024, time.May, 15, 10, 0),
+			want:     date(utc, 2024, time.May, 20, 0, 0),
+		},
+		{
+			name:     "weekly without a creation time does not drift with late resets",
+			strategy: ResetStrategyWeekly,
+			loc:      utc,
+			last:     date(utc, 2024, time.May, 21, 3, 0),
+			want:     date(utc, 2024, time.May, 27, 0, 0),
+		},
+		{
+			name:     "monthly without a creation time resets on the first",
+			strategy: ResetStrategyMonthly,
+			loc:      utc,
+			last:     date(utc, 2024, time.May, 15, 10, 0),
+			want:     date(utc, 2024, time.June, 1, 0, 0),
+		},
+		{
+			name:     "yearly without a creation time resets on January 1st",
+			strategy: ResetStrategyYearly,
+			loc:      utc,
+			last:     date(utc, 2024, time.May, 15, 10, 0),
+			want:     date(utc, 2025, time.January, 1, 0, 0),
+		},
+		{
+			name:     "daily across the start of daylight saving time",
+			strategy: ResetStrategyDaily,
+			loc:      berlin,
+			last:     date(berlin, 2024, time.March, 31, 0, 0),
+			want:     date(berlin, 2024, time.April, 1, 0, 0),
+		},
+		{
+			name:     "daily across the end of daylight saving time",
+			strategy: ResetStrategyDaily,
+			loc:      berlin,
+			last:     date(berlin, 2024, time.October, 27, 0, 0),
+			want:     date(berlin, 2024, time.October, 28, 0, 0),
+		},
+		{
+			name:     "weekly across daylight saving time",
+			strategy: ResetStrategyWeekly,
+			loc:      berlin,
+			created:  date(berlin, 2024, time.January, 1, 9, 0),
+			last:     date(berlin, 2024, time.March, 25, 0, 0),
+			want:     date(berlin, 2024, time.April, 1, 0, 0),
+		},
+		{
+			name:     "daily on a day without midnight",
+			strategy: ResetStrategyDaily,
+			loc:      santiago,
+			last:     date(santiago, 2024, time.September, 7, 10, 0),
+			want:     date(santiago, 2024, time.September, 8, 1, 0),
+		},
+		{
+			name:     "daily right after a day without midnight",
+			strategy: ResetStrategyDaily,
+			loc:      santiago,
+			last:     date(santiago, 2024, time.September, 8, 1, 0),
+			want:     date(santiago, 2024, time.September, 9, 0, 0),
+		},
+		{
+			name:     "monthly in the time zone of the settings",
+			strategy: ResetStrategyMonthly,
+			loc:      tehran,
+			created:  date(utc, 2024, time.January, 31, 22, 0),
+			last:     date(utc, 2024, time.May, 31, 23, 0),
+			want:     date(tehran, 2024, time.July, 1, 0, 0),
+		},
+		{
+			name:     "monthly in UTC for the same user",
+			strategy: ResetStrategyMonthly,
+			loc:      utc,
+			created:  date(utc, 2024, time.January, 31, 22, 0),
+			last:     date(utc, 2024, time.May, 31, 23, 0),
+			want:     date(utc, 2024, time.June, 30, 0, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &User{ResetStrategy: tt.strategy, UsageResetAt: tt.last.UnixMilli()}
+			if !tt.created.IsZero() {
+				u.CreatedAt = tt.created.UnixMilli()
+			}
+
+			got, scheduled := u.NextUsageReset(tt.loc)
+			if !scheduled {
+				t.Fatal("got no reset")
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want.In(tt.loc))
+			}
+		})
+	}
+}
+
+func TestNextUsageResetNone(t *testing.T) {
+	for _, strategy := range []string{"", ResetStrategyNone} {
+		u := &User{ResetStrategy: strategy, UsageResetAt: time.Now().UnixMilli()}
+		if next, scheduled := u.NextUsageReset(time.UTC); scheduled {
+			t.Errorf("strategy %q: got a reset at %s", strategy, next)
+		}
+	}
+}
+
+func TestUsageResetDue(t *testing.T) {
+	last := time.Date(2024, time.May, 10, 13, 0, 0, 0, time.UTC)
+	u := &User{ResetStrategy: ResetStrategyDaily, UsageResetAt: last.UnixMilli()}
+	next := time.Date(2024, time.May, 11, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		now  time.Time
+		want bool
+	}{
+		{now: next.Add(-time.Millisecond), want: false},
+		{now: next, want: true},
+		{now: next.Add(36 * time.Hour), want: true},
+	}
+
+	for _, tt := range tests {
+		if got := u.UsageResetDue(tt.now, time.UTC); got != tt.want {
+			t.Errorf("at %s: got %t, want %t", tt.now, got, tt.want)
+		}
+	}
+}
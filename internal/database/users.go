@@ -1,15 +1,157 @@
 package database
 
+import (
+	"slices"
+	"time"
+
+	"github.com/ebadidev/arch-manager/internal/utils"
+)
+
 type User struct {
-	Id                  int     `json:"id"`
-	Identity            string  `json:"identity" validate:"required"`
-	Name                string  `json:"name" validate:"required,min=1,max=64"`
-	Quota               float64 `json:"quota" validate:"min=0"`
-	Usage               float64 `json:"usage" validate:"min=0"`
-	UsageBytes          int64   `json:"usage_bytes" validate:"min=0"`
-	UsageResetAt        int64   `json:"usage_reset_at"`
-	Enabled             bool    `json:"enabled"`
-	ShadowsocksPassword string  `json:"shadowsocks_password" validate:"required,min=1,max=64"`
-	ShadowsocksMethod   string  `json:"shadowsocks_method" validate:"required"`
-	CreatedAt           int64   `json:"created_at"`
+	Id                  int                  `json:"id"`
+	Identity            string               `json:"identity" validate:"required"`
+	Name                string               `json:"name" validate:"required,min=1,max=64"`
+	Quota               float64              `json:"quota" validate:"min=0"`
+	Usage               float64              `json:"usage" validate:"min=0"`
+	UsageBytes          int64                `json:"usage_bytes" validate:"min=0"`
+	NodeUsageBytes      map[int]int64        `json:"node_usage_bytes"`
+	UsageResetAt        int64                `json:"usage_reset_at"`
+	ResetStrategy       string               `json:"reset_strategy" validate:"omitempty,oneof=none daily weekly monthly yearly"`
+	Enabled             bool                 `json:"enabled"`
+	ExpiresAt           int64                `json:"expires_at"`
+	ExpiresAfter        int64                `json:"expires_after"`
+	FirstUsedAt         int64                `json:"first_used_at"`
+	ShadowsocksPassword string               `json:"shadowsocks_password" validate:"required,min=1,max=64"`
+	ShadowsocksMethod   string               `json:"shadowsocks_method" validate:"required"`
+	UUID                string               `json:"uuid" validate:"required,uuid"`
+	TrojanPassword      string               `json:"trojan_password" validate:"required,min=1,max=64"`
+	Retired             []*RetiredCredential `json:"retired_credentials"`
+	CreatedAt           int64                `json:"created_at"`
+}
+
+// AddNodeUsage adds the given bytes to the usage of the user through the node with the given id.
+// The per-node usage breaks down UsageBytes and is reset with it.
+func (u *User) AddNodeUsage(nodeId int, bytes int64) {
+	if u.NodeUsageBytes == nil {
+		u.NodeUsageBytes = map[int]int64{}
+	}
+	u.NodeUsageBytes[nodeId] = utils.SafeSumI64(u.NodeUsageBytes[nodeId], bytes)
+}
+
+// Expired reports whether the user has an expiration date and it has passed.
+func (u *User) Expired(now time.Time) bool {
+	return u.ExpiresAt > 0 && now.UnixMilli() >= u.ExpiresAt
+}
+
+// OverQuota reports whether the user has a quota and its usage has gone over it.
+func (u *User) OverQuota() bool {
+	return u.Quota > 0 && u.Usage > u.Quota
+}
+
+// MarkUsed records the first traffic of the user.
+// A user that expires after its first use (ExpiresAfter) gets its expiration date then.
+func (u *User) MarkUsed(now time.Time) {
+	if u.FirstUsedAt > 0 {
+		return
+	}
+	u.FirstUsedAt = now.UnixMilli()
+	if u.ExpiresAfter > 0 {
+		u.ExpiresAt = u.FirstUsedAt + u.ExpiresAfter
+		u.ExpiresAfter = 0
+	}
+}
+
+// RemainingDays returns the number of days, rounded up, until the user expires, or false if it never expires.
+// The days of a user that expires after its first use are counted in full until then.
+func (u *User) RemainingDays(now time.Time) (int, bool) {
+	day := (24 * time.Hour).Milliseconds()
+	switch {
+	case u.ExpiresAt > 0:
+		return int(max(0, u.ExpiresAt-now.UnixMilli()+day-1) / day), true
+	case u.ExpiresAfter > 0:
+		return int((u.ExpiresAfter + day - 1) / day), true
+	default:
+		return 0, false
+	}
+}
+
+// ResetUsage clears the usage of the user and its per-node breakdown.
+func (u *User) ResetUsage() {
+	u.Usage = 0
+	u.UsageBytes = 0
+	u.NodeUsageBytes = map[int]int64{}
+}
+
+// Users returns all the users.
+func (d *Database) Users() []*User {
+	return d.Content.Users
+}
+
+// FindUser returns the user with the given id, or nil if there is none.
+func (d *Database) FindUser(id int) *User {
+	for _, u := range d.Content.Users {
+		if u.Id == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// FindUserByIdentity returns the user with the given current profile identity, or nil if there is none.
+// Retired identities do not find their user, as they must not lead to the current identity and credentials.
+func (d *Database) FindUserByIdentity(identity string) *User {
+	for _, u := range d.Content.Users {
+		if u.Identity == identity {
+			return u
+		}
+	}
+	return nil
+}
+
+// FindUserByRetiredIdentity returns the user with the given retired identity still in its grace period,
+// or nil if there is none.
+func (d *Database) FindUserByRetiredIdentity(identity string, now time.Time) *User {
+	if identity == "" {
+		return nil
+	}
+	for _, u := range d.Content.Users {
+		if slices.Contains(u.RetiredCredentials(CredentialIdentity, now), identity) {
+			return u
+		}
+	}
+	return nil
+}
+
+// FindUserByName returns the user with the given name, or nil if there is none.
+func (d *Database) FindUserByName(name string) *User {
+	for _, u := range d.Content.Users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// AddUser appends the given user to the users.
+func (d *Database) AddUser(user *User) {
+	d.Content.Users = append(d.Content.Users, user)
+}
+
+// DeleteUser removes the user with the given id and reports whether it existed.
+func (d *Database) DeleteUser(id int) bool {
+	return d.DeleteUsers(func(u *User) bool { return u.Id == id }) > 0
+}
+
+// DeleteUsers removes the users matching the given function and returns the number of removed users.
+func (d *Database) DeleteUsers(match func(u *User) bool) int {
+	users := make([]*User, 0, len(d.Content.Users))
+	for _, u := range d.Content.Users {
+		if !match(u) {
+			users = append(users, u)
+		}
+	}
+	deleted := len(d.Content.Users) - len(users)
+	d.Content.Users = users
+	d.deleteUsageHistories()
+	return deleted
 }
@@ -16,15 +16,12 @@ func Profile(config *config.Config, d *database.Database) echo.HandlerFunc {
 		c.Response().Header().Set("Pragma", "no-cache")
 		c.Response().Header().Set("Expires", "0")
 
-		userId := c.QueryParams().Get("u")
-		for _, u := range d.Content.Users {
-			if u.Identity == userId {
-				content, err := os.ReadFile(filepath.Join(config.Env.AppDirectory, "web/profile.html"))
-				if err != nil {
-					return err
-				}
-				return c.HTML(http.StatusOK, string(content))
+		if d.FindUserByIdentity(c.QueryParams().Get("u")) != nil {
+			content, err := os.ReadFile(filepath.Join(config.Env.AppDirectory, "web/profile.html"))
+			if err != nil {
+				return err
 			}
+			return c.HTML(http.StatusOK, string(content))
 		}
 
 		content, err := os.ReadFile(filepath.Join(config.Env.AppDirectory, "web/profile-404.html"))
@@ -27,15 +27,15 @@ func SignIn(d *database.Database, e *enigma.Enigma) echo.HandlerFunc {
 			})
 		}
 
-		if r.Username == "admin" && r.Password == d.Content.Settings.AdminPassword {
+		if r.Username == "admin" && r.Password == d.Settings().AdminPassword {
 			return c.JSON(http.StatusOK, map[string]string{
-				"token": d.Content.Settings.AdminPassword,
+				"token": d.Settings().AdminPassword,
 			})
 		}
 
-		if r.Username == "admin" && e.Verify(d.Content.Settings.Host, r.Password) {
+		if r.Username == "admin" && e.Verify(d.Settings().Host, r.Password) {
 			return c.JSON(http.StatusOK, map[string]string{
-				"token": d.Content.Settings.AdminPassword,
+				"token": d.Settings().AdminPassword,
 			})
 		}
 
@@ -0,0 +1,75 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/coordinator"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/labstack/echo/v4"
+)
+
+func BackupsIndex(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		backups, err := d.Backups()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return c.JSON(http.StatusOK, backups)
+	}
+}
+
+func BackupsStore(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		backup, err := d.CreateBackup()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return c.JSON(http.StatusCreated, backup)
+	}
+}
+
+func BackupsShow(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		if !database.BackupNamePattern.MatchString(name) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Validation error: invalid backup name.",
+			})
+		}
+
+		_, path, err := d.FindBackup(name)
+		if errors.Is(err, database.ErrBackupNotFound) {
+			return c.NoContent(http.StatusNotFound)
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return c.Attachment(path, name)
+	}
+}
+
+func BackupsRestore(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		if !database.BackupNamePattern.MatchString(name) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Validation error: invalid backup name.",
+			})
+		}
+
+		backup, err := d.RestoreBackup(name)
+		if errors.Is(err, database.ErrBackupNotFound) {
+			return c.NoContent(http.StatusNotFound)
+		} else if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Cannot restore the backup: %v", err.Error()),
+			})
+		}
+
+		go coordinator.SyncConfigs()
+
+		return c.JSON(http.StatusOK, backup)
+	}
+}
@@ -0,0 +1,138 @@
+package v1
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// userCardsTemplate is the printable page of the user cards, with as many cards per A4 page as fit.
+var userCardsTemplate = template.Must(template.New("cards").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+  body { font-family: sans-serif; margin: 0; padding: 8mm; }
+  .cards { display: flex; flex-wrap: wrap; gap: 6mm; }
+  .card { width: 85mm; border: 1px solid #999; border-radius: 3mm; padding: 4mm; box-sizing: border-box; page-break-inside: avoid; break-inside: avoid; }
+  .card h2 { margin: 0 0 2mm; font-size: 14pt; }
+  .card dl { display: grid; grid-template-columns: auto 1fr; gap: 1mm 3mm; margin: 0 0 2mm; font-size: 9pt; }
+  .card dt { color: #555; }
+  .card dd { margin: 0; }
+  .card svg { display: block; width: 60mm; height: 60mm; margin: 0 auto; }
+  .card .url { font-family: monospace; font-size: 6.5pt; word-break: break-all; text-align: center; margin-top: 2mm; }
+  @page { size: A4; margin: 0; }
+</style>
+</head>
+<body>
+<div class="cards">
+{{range .Cards}}<div class="card">
+  <h2>{{.Name}}</h2>
+  <dl>
+    <dt>Quota</dt><dd>{{.Quota}}</dd>
+    <dt>Expires</dt><dd>{{.Expiry}}</dd>
+  </dl>
+  {{.QR}}
+  <div class="url">{{.URL}}</div>
+</div>
+{{end}}</div>
+</body>
+</html>
+`))
+
+type userCard struct {
+	Name   string
+	Quota  string
+	Expiry string
+	URL    string
+	QR     template.HTML
+}
+
+// UsersCards renders printable cards of users, with their quota, expiration and subscription QR code.
+// It renders the user of the `id` path parameter, or else the users of the `ids` (comma-separated) and `enabled` filters.
+func UsersCards(cfg *config.Config, d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		enabledParam := c.QueryParam("enabled")
+		if enabledParam != "" && enabledParam != "true" && enabledParam != "false" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Invalid query parameter.",
+			})
+		}
+
+		var ids []int
+		if c.Param("id") != "" {
+			ids = append(ids, parseId(c.Param("id")))
+		} else if c.QueryParam("ids") != "" {
+			for _, id := range strings.Split(c.QueryParam("ids"), ",") {
+				ids = append(ids, parseId(strings.TrimSpace(id)))
+			}
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		var users []*database.User
+		for _, u := range d.Users() {
+			if ids != nil && !slices.Contains(ids, u.Id) {
+				continue
+			}
+			if enabledParam != "" && u.Enabled != (enabledParam == "true") {
+				continue
+			}
+			users = append(users, u)
+		}
+		if c.Param("id") != "" && len(users) == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "Not found.",
+			})
+		}
+
+		settings := d.Settings()
+		cards := make([]*userCard, 0, len(users))
+		for _, u := range users {
+			card := &userCard{
+				Name:   u.Name,
+				Quota:  "Unlimited",
+				Expiry: "Never",
+				URL:    subscriptionURL(cfg, settings, u),
+			}
+			if u.Quota > 0 {
+				card.Quota = fmt.Sprintf("%.2f GB", u.Quota*settings.TrafficRatio)
+			}
+			if u.ExpiresAt > 0 {
+				card.Expiry = time.UnixMilli(u.ExpiresAt).In(settings.Location()).Format("2006-01-02")
+			} else if days, expires := u.RemainingDays(time.Now()); expires {
+				card.Expiry = fmt.Sprintf("%d days after the first use", days)
+			}
+
+			svg, err := utils.QRSVG(card.URL, "M", 256)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			card.QR = template.HTML(svg)
+			cards = append(cards, card)
+		}
+
+		title := "Users"
+		if len(cards) == 1 {
+			title = cards[0].Name
+		}
+
+		var page bytes.Buffer
+		if err := userCardsTemplate.Execute(&page, map[string]interface{}{"Title": title, "Cards": cards}); err != nil {
+			return errors.WithStack(err)
+		}
+		return c.HTMLBlob(http.StatusOK, page.Bytes())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"slices"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/ebadidev/arch-manager/internal/database"
@@ -52,7 +53,7 @@ func ImportsStore(d *database.Database, hc *client.Client) echo.HandlerFunc {
 		defer d.Locker.Unlock()
 
 		var names []string
-		for _, u := range d.Content.Users {
+		for _, u := range d.Users() {
 			names = append(names, u.Name)
 		}
 
@@ -63,7 +64,19 @@ func ImportsStore(d *database.Database, hc *client.Client) echo.HandlerFunc {
 				continue
 			}
 			u.Id = d.GenerateUserId()
-			d.Content.Users = append(d.Content.Users, &u)
+			if u.UUID == "" {
+				u.UUID = d.GenerateUserUUID()
+			}
+			if u.TrojanPassword == "" {
+				u.TrojanPassword = d.GenerateUserTrojanPassword()
+			}
+			if u.ResetStrategy == "" {
+				u.ResetStrategy = d.Settings().DefaultResetStrategy()
+			}
+			if u.UsageResetAt < 1e12 {
+				u.UsageResetAt = time.Now().UnixMilli()
+			}
+			d.AddUser(&u)
 			results = append(results, fmt.Sprintf("Imported #%d: ID=%d Name=%s", users[i].Id, u.Id, u.Name))
 		}
 
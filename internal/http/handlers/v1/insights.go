@@ -4,17 +4,45 @@ import (
 	"net/http"
 
 	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
 	"github.com/labstack/echo/v4"
 )
 
+type InsightsNode struct {
+	Id         int     `json:"id"`
+	Name       string  `json:"name"`
+	UsageBytes int64   `json:"usage_bytes"`
+	Usage      float64 `json:"usage"`
+	Users      int     `json:"users"`
+}
+
 func InsightsIndex(d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		// Usage of the current period per node, from the per-node usage of the users.
+		nodes := []*InsightsNode{}
+		for _, n := range d.Nodes() {
+			node := &InsightsNode{Id: n.Id, Name: n.ServerName}
+			for _, u := range d.Users() {
+				if bytes := u.NodeUsageBytes[n.Id]; bytes > 0 {
+					node.UsageBytes = utils.SafeSumI64(node.UsageBytes, bytes)
+					node.Users++
+				}
+			}
+			node.Usage = utils.Bytes2GB(node.UsageBytes)
+			nodes = append(nodes, node)
+		}
+
 		return c.JSON(http.StatusOK, struct {
-			TotalUsers  int `json:"total_users"`
-			ActiveUsers int `json:"active_users"`
+			TotalUsers  int             `json:"total_users"`
+			ActiveUsers int             `json:"active_users"`
+			Nodes       []*InsightsNode `json:"nodes"`
 		}{
-			TotalUsers:  len(d.Content.Users),
+			TotalUsers:  len(d.Users()),
 			ActiveUsers: d.CountActiveUsers(),
+			Nodes:       nodes,
 		})
 	}
 }
@@ -1,8 +1,11 @@
 package v1
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -12,30 +15,104 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-func NodesConfigsShow(cdr *coordinator.Coordinator, writer *writer.Writer, d *database.Database) echo.HandlerFunc {
+func NodesConfigsShow(cdr *coordinator.Coordinator, w *writer.Writer, d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		nodeId := c.Param("id")
-		var node *database.Node
-		for _, n := range d.Content.Nodes {
-			if strconv.Itoa(n.Id) == nodeId {
-				node = n
-				node.PulledAt = time.Now().UnixMilli()
-				node.PullStatus = database.NodeStatusAvailable
-
-				if err := d.Save(); err != nil {
-					return errors.WithStack(err)
-				}
-			}
-		}
+		node := d.FindNode(parseId(c.Param("id")))
 		if node == nil {
 			return c.NoContent(http.StatusNotFound)
 		}
 
-		configs := writer.RemoteConfig(node, cdr.State().XrayUpdatedAt(), cdr.State().XraySharedPassword())
+		configs := w.RemoteConfig(node, cdr.State().XrayUpdatedAt(), cdr.State().XraySharedPassword())
+
+		// The node keeps its running config when the pull fails.
+		validationErr := writer.Validate(configs)
+		if validationErr != nil {
+			node.PullStatus = database.NodeStatusInvalid
+			node.ConfigError = validationErr.Error()
+			if err := w.SaveRejectedConfig(fmt.Sprintf("node-%d", node.Id), configs, validationErr); err != nil {
+				return errors.WithStack(err)
+			}
+		} else {
+			node.PulledAt = time.Now().UnixMilli()
+			node.PullStatus = database.NodeStatusAvailable
+			node.ConfigError = ""
+		}
+
+		if err := d.Save(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if validationErr != nil {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+				"message": fmt.Sprintf("Invalid configuration: %v", validationErr.Error()),
+			})
+		}
 
 		return c.JSON(http.StatusOK, configs)
 	}
 }
+
+// NodeConfigValidate validates the configuration of a node without applying it.
+// The request body is an optional node configuration to try instead of the saved one, as sent to NodeConfigUpdate.
+// An empty body validates the saved configuration, while a sent one is validated as is, even without inbounds.
+func NodeConfigValidate(cdr *coordinator.Coordinator, w *writer.Writer, d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot read the request body.",
+			})
+		}
+
+		var candidate *database.Node
+		if len(bytes.TrimSpace(body)) > 0 {
+			candidate = &database.Node{}
+			if err = json.Unmarshal(body, candidate); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "Cannot parse the request body.",
+				})
+			}
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		node := d.FindNode(parseId(c.Param("id")))
+		if node == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "Node not found",
+			})
+		}
+
+		if candidate != nil {
+			candidate.Id = node.Id
+			candidate.Host = node.Host
+			candidate.HttpToken = node.HttpToken
+			candidate.HttpPort = node.HttpPort
+			candidate.RestoreSecrets(node)
+			node = candidate
+		}
+
+		if err := writer.CheckNode(node); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"valid": false,
+				"error": err.Error(),
+			})
+		}
+
+		configs := w.RemoteConfig(node, cdr.State().XrayUpdatedAt(), cdr.State().XraySharedPassword())
+		if err := writer.Validate(configs); err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"valid": false,
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"valid": true,
+		})
+	}
+}
@@ -3,7 +3,6 @@ package v1
 import (
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/cockroachdb/errors"
 	"github.com/ebadidev/arch-manager/internal/coordinator"
@@ -34,13 +33,11 @@ type NodesUpdatePartialRequest struct {
 
 func NodesIndex(d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		token := d.Content.Settings.AdminPassword
-
-		var response = make([]NodeResponse, 0, len(d.Content.Nodes))
-		for _, node := range d.Content.Nodes {
-			cmd := fmt.Sprintf("make set-manager URL=\"BASE_URL/v1/nodes/%d\" TOKEN=\"%s\"", node.Id, token)
+		var response = make([]NodeResponse, 0, len(d.Nodes()))
+		for _, node := range d.Nodes() {
+			cmd := fmt.Sprintf("make set-manager URL=\"BASE_URL/v1/nodes/%d\" TOKEN=\"ADMIN_PASSWORD\"", node.Id)
 			response = append(response, NodeResponse{
-				Node:        *node,
+				Node:        *node.Redacted(),
 				PullCommand: cmd,
 			})
 		}
@@ -66,27 +63,23 @@ func NodesStore(coordinator *coordinator.Coordinator, d *database.Database) echo
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		if len(d.Content.Nodes) > 5 {
+		if len(d.Nodes()) > 5 {
 			return c.JSON(http.StatusForbidden, map[string]string{
 				"message": fmt.Sprintf("Cannot add more nodes!"),
 			})
 		}
 
-		var node *database.Node
-		for _, n := range d.Content.Nodes {
-			if n.Host == r.Host && n.HttpPort == r.HttpPort {
-				node = n
-				node.HttpToken = r.HttpToken
-			}
-		}
-		if node == nil {
+		node := d.FindNodeByAddress(r.Host, r.HttpPort)
+		if node != nil {
+			node.HttpToken = r.HttpToken
+		} else {
 			node = &database.Node{}
 			node.Id = d.GenerateNodeId()
 			node.HttpToken = r.HttpToken
 			node.Host = r.Host
 			node.HttpPort = r.HttpPort
 
-			d.Content.Nodes = append(d.Content.Nodes, node)
+			d.AddNode(node)
 		}
 
 		if err := d.Save(); err != nil {
@@ -95,7 +88,7 @@ func NodesStore(coordinator *coordinator.Coordinator, d *database.Database) echo
 
 		go coordinator.SyncConfigs()
 
-		return c.JSON(http.StatusCreated, node)
+		return c.JSON(http.StatusCreated, node.Redacted())
 	}
 }
 
@@ -116,19 +109,16 @@ func NodesUpdate(coordinator *coordinator.Coordinator, d *database.Database) ech
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		var node *database.Node
-		for _, n := range d.Content.Nodes {
-			if strconv.Itoa(n.Id) == c.Param("id") {
-				node = n
-			}
-		}
+		node := d.FindNode(parseId(c.Param("id")))
 		if node == nil {
 			return c.JSON(http.StatusNotFound, map[string]string{"message": "Not found."})
 		}
 
 		node.Host = r.Host
-		node.HttpToken = r.HttpToken
 		node.HttpPort = r.HttpPort
+		if r.HttpToken != database.Redacted {
+			node.HttpToken = r.HttpToken
+		}
 
 		if err := d.Save(); err != nil {
 			return errors.WithStack(err)
@@ -136,7 +126,7 @@ func NodesUpdate(coordinator *coordinator.Coordinator, d *database.Database) ech
 
 		go coordinator.SyncConfigs()
 
-		return c.JSON(http.StatusOK, node)
+		return c.JSON(http.StatusOK, node.Redacted())
 
 	}
 }
@@ -158,7 +148,7 @@ func NodesUpdatePartialBatch(coordinator *coordinator.Coordinator, d *database.D
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		for _, node := range d.Content.Nodes {
+		for _, node := range d.Nodes() {
 			if request.Usage != nil {
 				node.Usage = *request.Usage
 				node.UsageBytes = utils.GB2Bytes(*request.Usage)
@@ -180,15 +170,11 @@ func NodesDelete(coordinator *coordinator.Coordinator, d *database.Database) ech
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		for i, s := range d.Content.Nodes {
-			if strconv.Itoa(s.Id) == c.Param("id") {
-				d.Content.Nodes = append(d.Content.Nodes[:i], d.Content.Nodes[i+1:]...)
-				if err := d.Save(); err != nil {
-					return errors.WithStack(err)
-				}
-				go coordinator.SyncConfigs()
-				break
+		if d.DeleteNode(parseId(c.Param("id"))) {
+			if err := d.Save(); err != nil {
+				return errors.WithStack(err)
 			}
+			go coordinator.SyncConfigs()
 		}
 
 		return c.NoContent(http.StatusNoContent)
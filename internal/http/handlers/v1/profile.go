@@ -6,16 +6,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/ebadidev/arch-manager/internal/coordinator"
 	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 )
 
 type ProfileResponse struct {
-	User        database.User           `json:"user"`
-	Connections []ConnectionInfo        `json:"connections"`
+	User          database.User       `json:"user"`
+	RemainingDays *int                `json:"remaining_days"` // Days until the user expires, null if it never does
+	Connections   []ConnectionInfo    `json:"connections"`
+	UsageHistory  ProfileUsageHistory `json:"usage_history"`
+}
+
+// ProfileUsageHistory holds the charts of the user consumption, in the same format as the admin usage endpoint.
+type ProfileUsageHistory struct {
+	Hourly *UsageSeriesResponse `json:"hourly"`
+	Daily  *UsageSeriesResponse `json:"daily"`
 }
 
 type ConnectionInfo struct {
@@ -23,18 +34,14 @@ type ConnectionInfo struct {
 	Protocol    string `json:"protocol"`    // "shadowsocks", "vmess", "vless", "trojan"
 	Transport   string `json:"transport"`   // "tcp", "ws", "grpc", "http", etc.
 	Name        string `json:"name"`        // Display name
+	Inbound     string `json:"inbound"`     // Tag of the node inbound
 	Link        string `json:"link"`        // Connection URL
 	Port        int    `json:"port"`        // Connection port
 }
 
 func ProfileShow(d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		var user *database.User
-		for _, u := range d.Content.Users {
-			if u.Identity == c.QueryParam("u") {
-				user = u
-			}
-		}
+		user := d.FindUserByIdentity(c.QueryParam("u"))
 		if user == nil {
 			return c.JSON(http.StatusNotFound, map[string]string{
 				"message": "Not found.",
@@ -42,64 +49,83 @@ func ProfileShow(d *database.Database) echo.HandlerFunc {
 		}
 
 		r := ProfileResponse{User: *user}
-		r.User.Usage = r.User.Usage * d.Content.Settings.TrafficRatio
-		r.User.Quota = r.User.Quota * d.Content.Settings.TrafficRatio
+		r.User.Usage = r.User.Usage * d.Settings().TrafficRatio
+		r.User.Quota = r.User.Quota * d.Settings().TrafficRatio
+
+		now := time.Now()
+		if days, expires := user.RemainingDays(now); expires {
+			r.RemainingDays = &days
+		}
 
 		// Generate connection info based on actual configurations
 		r.Connections = generateConnectionInfo(d, user)
 
+		ratio := d.Settings().TrafficRatio
+		d.Locker.Lock()
+		r.UsageHistory.Hourly = makeUsageSeriesResponse(d, user.Id, database.UsageStepHour, now.Add(-23*time.Hour), now, ratio)
+		r.UsageHistory.Daily = makeUsageSeriesResponse(d, user.Id, database.UsageStepDay, now.AddDate(0, 0, -29), now, ratio)
+		d.Locker.Unlock()
+
 		return c.JSON(http.StatusOK, r)
 	}
 }
 
 func generateConnectionInfo(d *database.Database, user *database.User) []ConnectionInfo {
 	var connections []ConnectionInfo
-	s := d.Content.Settings
+	s := d.Settings()
 
-	// Only generate connections from actual configured nodes
+	// Only generate connections from the inbounds of actual configured nodes
 	// Internal Shadowsocks connections are hidden from users
-	for _, node := range d.Content.Nodes {
-		if node.Protocol == "" || node.ServerPort == "" {
-			continue
-		}
-		
-		// Skip internal Shadowsocks connections (used for manager-node communication)
-		// Users should only see their configured client-facing protocols
-		if isInternalConnection(node) {
-			continue
+	// Inbounds with a server port range have a connection on each of their hop ports as well
+	for _, node := range d.Nodes() {
+		var inbounds []*database.Inbound
+		for _, in := range node.Inbounds {
+			inbounds = append(append(inbounds, in), in.Hops()...)
 		}
+		for _, in := range inbounds {
+			if in.Protocol == "" || in.ServerPort == "" {
+				continue
+			}
 
-		// Create connection info based on node configuration
-		connInfo := ConnectionInfo{
-			Type:      "remote",
-			Protocol:  node.Protocol,
-			Transport: node.NetworkSettings.Transport,
-			Port:      node.ListeningPort,
-		}
+			// Skip internal Shadowsocks connections (used for manager-node communication)
+			// Users should only see their configured client-facing protocols
+			if isInternalConnection(node) {
+				continue
+			}
 
-		// Set display name
-		if node.NetworkSettings.Transport != "" && node.NetworkSettings.Transport != "tcp" {
-			connInfo.Name = fmt.Sprintf("%s (%s)", 
-				formatProtocolName(node.Protocol), 
-				formatTransportName(node.NetworkSettings.Transport))
-		} else {
-			connInfo.Name = formatProtocolName(node.Protocol)
-		}
+			// Create connection info based on inbound configuration
+			connInfo := ConnectionInfo{
+				Type:      "remote",
+				Protocol:  in.Protocol,
+				Transport: in.NetworkSettings.Transport,
+				Inbound:   in.Tag,
+				Port:      in.ListeningPort,
+			}
 
-		// Generate appropriate connection link
-		switch node.Protocol {
-		case "vmess":
-			connInfo.Link = generateVMessLink(node, user, s)
-		case "vless":
-			connInfo.Link = generateVLESSLink(node, user, s)
-		case "trojan":
-			connInfo.Link = generateTrojanLink(node, user, s)
-		case "shadowsocks":
-			connInfo.Link = generateShadowsocksLink(node, user, s)
-		}
+			// Set display name
+			if in.NetworkSettings.Transport != "" && in.NetworkSettings.Transport != "tcp" {
+				connInfo.Name = fmt.Sprintf("%s (%s)",
+					formatProtocolName(in.Protocol),
+					formatTransportName(in.NetworkSettings.Transport))
+			} else {
+				connInfo.Name = formatProtocolName(in.Protocol)
+			}
+
+			// Generate appropriate connection link
+			switch in.Protocol {
+			case "vmess":
+				connInfo.Link = generateVMessLink(node, in, user, s)
+			case "vless":
+				connInfo.Link = generateVLESSLink(node, in, user, s)
+			case "trojan":
+				connInfo.Link = generateTrojanLink(node, in, user, s)
+			case "shadowsocks":
+				connInfo.Link = generateShadowsocksLink(node, in, user, s)
+			}
 
-		if connInfo.Link != "" {
-			connections = append(connections, connInfo)
+			if connInfo.Link != "" {
+				connections = append(connections, connInfo)
+			}
 		}
 	}
 
@@ -148,17 +174,17 @@ func formatTransportName(transport string) string {
 	}
 }
 
-func generateVMessLink(node *database.Node, user *database.User, settings *database.Settings) string {
+func generateVMessLink(node *database.Node, in *database.Inbound, user *database.User, settings *database.Settings) string {
 	// VMess link format: vmess://base64(json_config)
 	config := map[string]interface{}{
 		"v":    "2",
-		"ps":   node.ServerName,
+		"ps":   node.InboundName(in),
 		"add":  settings.Host,
-		"port": node.ListeningPort,
-		"id":   generateUserUUID(user), // Generate UUID based on user
+		"port": in.ListeningPort,
+		"id":   user.UUID,
 		"aid":  "0",
-		"scy":  node.Encryption,
-		"net":  node.NetworkSettings.Transport,
+		"scy":  in.Encryption,
+		"net":  linkNetwork(in),
 		"type": "none",
 		"host": "",
 		"path": "",
@@ -166,27 +192,27 @@ func generateVMessLink(node *database.Node, user *database.User, settings *datab
 	}
 
 	// Set security (TLS) field based on node security configuration
-	switch node.Security {
+	switch in.Security {
 	case "tls":
 		config["tls"] = "tls"
 		// Add TLS-specific settings if available
-		if node.SecuritySettings.TLS != nil {
-			if node.SecuritySettings.TLS.SNI != "" {
-				config["sni"] = node.SecuritySettings.TLS.SNI
-			} else if node.SecuritySettings.TLS.ServerName != "" {
-				config["sni"] = node.SecuritySettings.TLS.ServerName // fallback to server_name
+		if in.SecuritySettings.TLS != nil {
+			if in.SecuritySettings.TLS.SNI != "" {
+				config["sni"] = in.SecuritySettings.TLS.SNI
+			} else if in.SecuritySettings.TLS.ServerName != "" {
+				config["sni"] = in.SecuritySettings.TLS.ServerName // fallback to server_name
 			}
 			
 			// Add fingerprint if configured
-			if node.SecuritySettings.TLS.Fingerprint != "" {
-				config["fp"] = node.SecuritySettings.TLS.Fingerprint
+			if in.SecuritySettings.TLS.Fingerprint != "" {
+				config["fp"] = in.SecuritySettings.TLS.Fingerprint
 			}
 			
 			// Add ALPN if configured
-			if len(node.SecuritySettings.TLS.ALPN) > 0 {
+			if len(in.SecuritySettings.TLS.ALPN) > 0 {
 				// Join ALPN protocols with comma
 				alpnStr := ""
-				for i, protocol := range node.SecuritySettings.TLS.ALPN {
+				for i, protocol := range in.SecuritySettings.TLS.ALPN {
 					if i > 0 {
 						alpnStr += ","
 					}
@@ -204,19 +230,19 @@ func generateVMessLink(node *database.Node, user *database.User, settings *datab
 	}
 
 	// Add transport-specific settings
-	if node.NetworkSettings.Settings != nil {
-		switch node.NetworkSettings.Transport {
-		case "ws":
-			// WebSocket transport settings
-			if path, exists := node.NetworkSettings.Settings["path"]; exists {
+	if in.NetworkSettings.Settings != nil {
+		switch in.NetworkSettings.Transport {
+		case "ws", "httpupgrade", "xhttp":
+			// WebSocket, HTTPUpgrade and XHTTP transport settings
+			if path, exists := in.NetworkSettings.Settings["path"]; exists {
 				config["path"] = path
 			}
-			if host, exists := node.NetworkSettings.Settings["host"]; exists {
+			if host, exists := in.NetworkSettings.Settings["host"]; exists {
 				config["host"] = host
 			}
 		case "kcp":
 			// KCP transport settings
-			if header, exists := node.NetworkSettings.Settings["header"]; exists {
+			if header, exists := in.NetworkSettings.Settings["header"]; exists {
 				if headerMap, ok := header.(map[string]interface{}); ok {
 					if headerType, exists := headerMap["type"]; exists {
 						config["type"] = headerType // KCP header type (none, srtp, utp, etc.)
@@ -226,25 +252,24 @@ func generateVMessLink(node *database.Node, user *database.User, settings *datab
 					}
 				}
 			}
-			if seed, exists := node.NetworkSettings.Settings["seed"]; exists {
+			if seed, exists := in.NetworkSettings.Settings["seed"]; exists {
 				config["path"] = seed // KCP seed goes in path field
 			}
 		case "grpc":
 			// gRPC transport settings
-			if serviceName, exists := node.NetworkSettings.Settings["serviceName"]; exists {
+			if serviceName, exists := in.NetworkSettings.Settings["serviceName"]; exists {
 				config["path"] = serviceName // gRPC service name goes in path
 			}
-			if authority, exists := node.NetworkSettings.Settings["authority"]; exists {
+			if authority, exists := in.NetworkSettings.Settings["authority"]; exists {
 				config["host"] = authority // gRPC authority goes in host
 			}
 		case "http":
-			// HTTP transport settings
-			config["net"] = "tcp" // HTTP transport uses TCP network
+			// HTTP transport settings, served as TCP with HTTP header obfuscation
 			config["type"] = "http" // Set header type to http
-			if path, exists := node.NetworkSettings.Settings["path"]; exists {
+			if path, exists := in.NetworkSettings.Settings["path"]; exists {
 				config["path"] = path
 			}
-			if host, exists := node.NetworkSettings.Settings["host"]; exists {
+			if host, exists := in.NetworkSettings.Settings["host"]; exists {
 				if hosts, ok := host.([]interface{}); ok && len(hosts) > 0 {
 					// Use first host from array
 					config["host"] = hosts[0]
@@ -252,6 +277,25 @@ func generateVMessLink(node *database.Node, user *database.User, settings *datab
 					config["host"] = host
 				}
 			}
+		case "tcp":
+			// TCP transport with optional HTTP header
+			if header, exists := in.NetworkSettings.Settings["header"]; exists {
+				if headerMap, ok := header.(map[string]interface{}); ok {
+					if headerType, exists := headerMap["type"]; exists && headerType == "http" {
+						config["type"] = "http"
+						if path, exists := headerMap["path"]; exists {
+							config["path"] = path
+						}
+						if host, exists := headerMap["host"]; exists {
+							if hosts, ok := host.([]interface{}); ok && len(hosts) > 0 {
+								config["host"] = hosts[0]
+							} else {
+								config["host"] = host
+							}
+						}
+					}
+				}
+			}
 		}
 	}
 
@@ -264,38 +308,37 @@ func generateVMessLink(node *database.Node, user *database.User, settings *datab
 	return "vmess://" + base64.StdEncoding.EncodeToString(jsonBytes)
 }
 
-func generateVLESSLink(node *database.Node, user *database.User, settings *database.Settings) string {
+func generateVLESSLink(node *database.Node, in *database.Inbound, user *database.User, settings *database.Settings) string {
 	// VLESS link format: vless://uuid@host:port?params#name
-	uuid := generateUserUUID(user)
-	baseURL := fmt.Sprintf("vless://%s@%s:%d", uuid, settings.Host, node.ListeningPort)
+	baseURL := fmt.Sprintf("vless://%s@%s:%d", user.UUID, settings.Host, in.ListeningPort)
 	
 	params := []string{
 		"encryption=none",
-		fmt.Sprintf("type=%s", node.NetworkSettings.Transport),
+		fmt.Sprintf("type=%s", linkNetwork(in)),
 	}
 	
 	// Add security settings
-	switch node.Security {
+	switch in.Security {
 	case "tls":
 		params = append(params, "security=tls")
 		
-		if node.SecuritySettings.TLS != nil {
+		if in.SecuritySettings.TLS != nil {
 			// Add SNI
-			if node.SecuritySettings.TLS.SNI != "" {
-				params = append(params, fmt.Sprintf("sni=%s", node.SecuritySettings.TLS.SNI))
-			} else if node.SecuritySettings.TLS.ServerName != "" {
-				params = append(params, fmt.Sprintf("sni=%s", node.SecuritySettings.TLS.ServerName))
+			if in.SecuritySettings.TLS.SNI != "" {
+				params = append(params, fmt.Sprintf("sni=%s", in.SecuritySettings.TLS.SNI))
+			} else if in.SecuritySettings.TLS.ServerName != "" {
+				params = append(params, fmt.Sprintf("sni=%s", in.SecuritySettings.TLS.ServerName))
 			}
 			
 			// Add fingerprint
-			if node.SecuritySettings.TLS.Fingerprint != "" {
-				params = append(params, fmt.Sprintf("fp=%s", node.SecuritySettings.TLS.Fingerprint))
+			if in.SecuritySettings.TLS.Fingerprint != "" {
+				params = append(params, fmt.Sprintf("fp=%s", in.SecuritySettings.TLS.Fingerprint))
 			}
 			
 			// Add ALPN
-			if len(node.SecuritySettings.TLS.ALPN) > 0 {
+			if len(in.SecuritySettings.TLS.ALPN) > 0 {
 				alpnStr := ""
-				for i, protocol := range node.SecuritySettings.TLS.ALPN {
+				for i, protocol := range in.SecuritySettings.TLS.ALPN {
 					if i > 0 {
 						alpnStr += ","
 					}
@@ -305,37 +348,37 @@ func generateVLESSLink(node *database.Node, user *database.User, settings *datab
 			}
 			
 			// Add allowInsecure
-			if node.SecuritySettings.TLS.AllowInsecure {
+			if in.SecuritySettings.TLS.AllowInsecure {
 				params = append(params, "allowInsecure=1")
 			}
 		}
 	case "reality":
 		params = append(params, "security=reality")
 		
-		if node.SecuritySettings.Reality != nil {
+		if in.SecuritySettings.Reality != nil {
 			// Add Reality fingerprint (most commonly configured)
-			if node.SecuritySettings.Reality.Fingerprint != "" {
-				params = append(params, fmt.Sprintf("fp=%s", node.SecuritySettings.Reality.Fingerprint))
+			if in.SecuritySettings.Reality.Fingerprint != "" {
+				params = append(params, fmt.Sprintf("fp=%s", in.SecuritySettings.Reality.Fingerprint))
 			}
 			
 			// Add Reality server names (SNI)
-			if len(node.SecuritySettings.Reality.ServerNames) > 0 {
-				params = append(params, fmt.Sprintf("sni=%s", node.SecuritySettings.Reality.ServerNames[0]))
+			if len(in.SecuritySettings.Reality.ServerNames) > 0 {
+				params = append(params, fmt.Sprintf("sni=%s", in.SecuritySettings.Reality.ServerNames[0]))
 			}
 			
 			// Add Reality public key (critical for connection)
-			if node.SecuritySettings.Reality.PublicKey != "" {
-				params = append(params, fmt.Sprintf("pbk=%s", node.SecuritySettings.Reality.PublicKey))
+			if in.SecuritySettings.Reality.PublicKey != "" {
+				params = append(params, fmt.Sprintf("pbk=%s", in.SecuritySettings.Reality.PublicKey))
 			}
 			
 			// Add Reality short ID (use first one if multiple)
-			if len(node.SecuritySettings.Reality.ShortIDs) > 0 {
-				params = append(params, fmt.Sprintf("sid=%s", node.SecuritySettings.Reality.ShortIDs[0]))
+			if len(in.SecuritySettings.Reality.ShortIDs) > 0 {
+				params = append(params, fmt.Sprintf("sid=%s", in.SecuritySettings.Reality.ShortIDs[0]))
 			}
 			
 			// Add Reality spider X (spx) if configured
-			if node.SecuritySettings.Reality.SpiderX != "" {
-				params = append(params, fmt.Sprintf("spx=%s", node.SecuritySettings.Reality.SpiderX))
+			if in.SecuritySettings.Reality.SpiderX != "" {
+				params = append(params, fmt.Sprintf("spx=%s", in.SecuritySettings.Reality.SpiderX))
 			}
 		}
 	case "none":
@@ -346,28 +389,29 @@ func generateVLESSLink(node *database.Node, user *database.User, settings *datab
 	}
 	
 	// Add transport-specific parameters
-	if node.NetworkSettings.Settings != nil {
-		switch node.NetworkSettings.Transport {
-		case "ws":
-			if path, exists := node.NetworkSettings.Settings["path"]; exists {
+	if in.NetworkSettings.Settings != nil {
+		switch in.NetworkSettings.Transport {
+		case "ws", "httpupgrade":
+			if path, exists := in.NetworkSettings.Settings["path"]; exists {
 				params = append(params, fmt.Sprintf("path=%s", path))
 			}
-			if host, exists := node.NetworkSettings.Settings["host"]; exists {
+			if host, exists := in.NetworkSettings.Settings["host"]; exists {
 				params = append(params, fmt.Sprintf("host=%s", host))
 			}
 		case "grpc":
-			if serviceName, exists := node.NetworkSettings.Settings["serviceName"]; exists {
+			if serviceName, exists := in.NetworkSettings.Settings["serviceName"]; exists {
 				params = append(params, fmt.Sprintf("serviceName=%s", serviceName))
 			}
-			if authority, exists := node.NetworkSettings.Settings["authority"]; exists {
+			if authority, exists := in.NetworkSettings.Settings["authority"]; exists {
 				params = append(params, fmt.Sprintf("authority=%s", authority))
 			}
 		case "http":
-			// HTTP+TCP transport settings
-			if path, exists := node.NetworkSettings.Settings["path"]; exists {
+			// HTTP transport settings, served as TCP with HTTP header obfuscation
+			params = append(params, "headerType=http")
+			if path, exists := in.NetworkSettings.Settings["path"]; exists {
 				params = append(params, fmt.Sprintf("path=%s", path))
 			}
-			if host, exists := node.NetworkSettings.Settings["host"]; exists {
+			if host, exists := in.NetworkSettings.Settings["host"]; exists {
 				if hosts, ok := host.([]interface{}); ok && len(hosts) > 0 {
 					params = append(params, fmt.Sprintf("host=%s", hosts[0]))
 				} else {
@@ -376,10 +420,10 @@ func generateVLESSLink(node *database.Node, user *database.User, settings *datab
 			}
 		case "xhttp":
 			// XHTTP transport settings
-			if path, exists := node.NetworkSettings.Settings["path"]; exists {
+			if path, exists := in.NetworkSettings.Settings["path"]; exists {
 				params = append(params, fmt.Sprintf("path=%s", path))
 			}
-			if host, exists := node.NetworkSettings.Settings["host"]; exists {
+			if host, exists := in.NetworkSettings.Settings["host"]; exists {
 				if hosts, ok := host.([]interface{}); ok && len(hosts) > 0 {
 					params = append(params, fmt.Sprintf("host=%s", hosts[0]))
 				} else {
@@ -387,25 +431,25 @@ func generateVLESSLink(node *database.Node, user *database.User, settings *datab
 				}
 			}
 			// XHTTP-specific parameters
-			if mode, exists := node.NetworkSettings.Settings["mode"]; exists {
+			if mode, exists := in.NetworkSettings.Settings["mode"]; exists {
 				params = append(params, fmt.Sprintf("mode=%s", mode))
 			}
-			if customHost, exists := node.NetworkSettings.Settings["custom_host"]; exists {
+			if customHost, exists := in.NetworkSettings.Settings["custom_host"]; exists {
 				params = append(params, fmt.Sprintf("custom_host=%s", customHost))
 			}
-			if noGRPCHeader, exists := node.NetworkSettings.Settings["noGRPCHeader"]; exists {
+			if noGRPCHeader, exists := in.NetworkSettings.Settings["noGRPCHeader"]; exists {
 				if noGRPC, ok := noGRPCHeader.(bool); ok && noGRPC {
 					params = append(params, "noGRPCHeader=true")
 				}
 			}
-			if noSSEHeader, exists := node.NetworkSettings.Settings["noSSEHeader"]; exists {
+			if noSSEHeader, exists := in.NetworkSettings.Settings["noSSEHeader"]; exists {
 				if noSSE, ok := noSSEHeader.(bool); ok && noSSE {
 					params = append(params, "noSSEHeader=true")
 				}
 			}
 		case "kcp":
 			// KCP transport settings
-			if header, exists := node.NetworkSettings.Settings["header"]; exists {
+			if header, exists := in.NetworkSettings.Settings["header"]; exists {
 				if headerMap, ok := header.(map[string]interface{}); ok {
 					if headerType, exists := headerMap["type"]; exists {
 						params = append(params, fmt.Sprintf("headerType=%s", headerType))
@@ -415,15 +459,16 @@ func generateVLESSLink(node *database.Node, user *database.User, settings *datab
 					}
 				}
 			}
-			if seed, exists := node.NetworkSettings.Settings["seed"]; exists {
+			if seed, exists := in.NetworkSettings.Settings["seed"]; exists {
 				params = append(params, fmt.Sprintf("seed=%s", seed))
 			}
 		case "tcp":
 			// TCP transport with optional HTTP header
-			if header, exists := node.NetworkSettings.Settings["header"]; exists {
+			if header, exists := in.NetworkSettings.Settings["header"]; exists {
 				if headerMap, ok := header.(map[string]interface{}); ok {
 					if headerType, exists := headerMap["type"]; exists && headerType == "http" {
 						// TCP with HTTP header obfuscation
+						params = append(params, "headerType=http")
 						if path, exists := headerMap["path"]; exists {
 							params = append(params, fmt.Sprintf("path=%s", path))
 						}
@@ -440,12 +485,17 @@ func generateVLESSLink(node *database.Node, user *database.User, settings *datab
 		}
 	}
 	
-	return fmt.Sprintf("%s?%s#%s", baseURL, joinParams(params), node.ServerName)
+	// Fragment hint, for the clients that fragment the connections by links
+	if fragment := utils.InboundFragment(in.Fragment, in.FragmentValue); fragment != nil {
+		params = append(params, fmt.Sprintf("fragment=%s", fragment))
+	}
+	
+	return fmt.Sprintf("%s?%s#%s", baseURL, joinParams(params), node.InboundName(in))
 }
 
-func generateTrojanLink(node *database.Node, user *database.User, settings *database.Settings) string {
+func generateTrojanLink(node *database.Node, in *database.Inbound, user *database.User, settings *database.Settings) string {
 	// Trojan requires TLS security - validate using tagged switch
-	switch node.Security {
+	switch in.Security {
 	case "tls":
 		// Valid - continue with link generation
 	default:
@@ -454,32 +504,31 @@ func generateTrojanLink(node *database.Node, user *database.User, settings *data
 	}
 	
 	// Trojan link format: trojan://password@host:port?params#name
-	password := generateTrojanPassword(user)
-	baseURL := fmt.Sprintf("trojan://%s@%s:%d", password, settings.Host, node.ListeningPort)
+	baseURL := fmt.Sprintf("trojan://%s@%s:%d", user.TrojanPassword, settings.Host, in.ListeningPort)
 	
 	params := []string{
-		fmt.Sprintf("type=%s", node.NetworkSettings.Transport),
+		fmt.Sprintf("type=%s", linkNetwork(in)),
 		"security=tls", // Always TLS for Trojan
 	}
 	
 	// Add TLS settings (required for Trojan)
-	if node.SecuritySettings.TLS != nil {
+	if in.SecuritySettings.TLS != nil {
 		// Add SNI
-		if node.SecuritySettings.TLS.SNI != "" {
-			params = append(params, fmt.Sprintf("sni=%s", node.SecuritySettings.TLS.SNI))
-		} else if node.SecuritySettings.TLS.ServerName != "" {
-			params = append(params, fmt.Sprintf("sni=%s", node.SecuritySettings.TLS.ServerName))
+		if in.SecuritySettings.TLS.SNI != "" {
+			params = append(params, fmt.Sprintf("sni=%s", in.SecuritySettings.TLS.SNI))
+		} else if in.SecuritySettings.TLS.ServerName != "" {
+			params = append(params, fmt.Sprintf("sni=%s", in.SecuritySettings.TLS.ServerName))
 		}
 		
 		// Add fingerprint
-		if node.SecuritySettings.TLS.Fingerprint != "" {
-			params = append(params, fmt.Sprintf("fp=%s", node.SecuritySettings.TLS.Fingerprint))
+		if in.SecuritySettings.TLS.Fingerprint != "" {
+			params = append(params, fmt.Sprintf("fp=%s", in.SecuritySettings.TLS.Fingerprint))
 		}
 		
 		// Add ALPN
-		if len(node.SecuritySettings.TLS.ALPN) > 0 {
+		if len(in.SecuritySettings.TLS.ALPN) > 0 {
 			alpnStr := ""
-			for i, protocol := range node.SecuritySettings.TLS.ALPN {
+			for i, protocol := range in.SecuritySettings.TLS.ALPN {
 				if i > 0 {
 					alpnStr += ","
 				}
@@ -489,43 +538,73 @@ func generateTrojanLink(node *database.Node, user *database.User, settings *data
 		}
 		
 		// Add allowInsecure
-		if node.SecuritySettings.TLS.AllowInsecure {
+		if in.SecuritySettings.TLS.AllowInsecure {
 			params = append(params, "allowInsecure=1")
 		}
 	}
 	
 	// Add transport-specific parameters
-	if node.NetworkSettings.Settings != nil {
-		switch node.NetworkSettings.Transport {
-		case "ws":
-			if path, exists := node.NetworkSettings.Settings["path"]; exists {
+	if in.NetworkSettings.Settings != nil {
+		switch in.NetworkSettings.Transport {
+		case "ws", "httpupgrade":
+			if path, exists := in.NetworkSettings.Settings["path"]; exists {
 				params = append(params, fmt.Sprintf("path=%s", path))
 			}
-			if host, exists := node.NetworkSettings.Settings["host"]; exists {
+			if host, exists := in.NetworkSettings.Settings["host"]; exists {
 				params = append(params, fmt.Sprintf("host=%s", host))
 			}
 		case "grpc":
-			if serviceName, exists := node.NetworkSettings.Settings["serviceName"]; exists {
+			if serviceName, exists := in.NetworkSettings.Settings["serviceName"]; exists {
 				params = append(params, fmt.Sprintf("serviceName=%s", serviceName))
 			}
-			if authority, exists := node.NetworkSettings.Settings["authority"]; exists {
+			if authority, exists := in.NetworkSettings.Settings["authority"]; exists {
 				params = append(params, fmt.Sprintf("authority=%s", authority))
 			}
 		case "http":
-			// HTTP+TCP transport settings
-			if path, exists := node.NetworkSettings.Settings["path"]; exists {
+			// HTTP transport settings, served as TCP with HTTP header obfuscation
+			params = append(params, "headerType=http")
+			if path, exists := in.NetworkSettings.Settings["path"]; exists {
+				params = append(params, fmt.Sprintf("path=%s", path))
+			}
+			if host, exists := in.NetworkSettings.Settings["host"]; exists {
+				if hosts, ok := host.([]interface{}); ok && len(hosts) > 0 {
+					params = append(params, fmt.Sprintf("host=%s", hosts[0]))
+				} else {
+					params = append(params, fmt.Sprintf("host=%s", host))
+				}
+			}
+		case "xhttp":
+			// XHTTP transport settings
+			if path, exists := in.NetworkSettings.Settings["path"]; exists {
 				params = append(params, fmt.Sprintf("path=%s", path))
 			}
-			if host, exists := node.NetworkSettings.Settings["host"]; exists {
+			if host, exists := in.NetworkSettings.Settings["host"]; exists {
 				if hosts, ok := host.([]interface{}); ok && len(hosts) > 0 {
 					params = append(params, fmt.Sprintf("host=%s", hosts[0]))
 				} else {
 					params = append(params, fmt.Sprintf("host=%s", host))
 				}
 			}
+			// XHTTP-specific parameters
+			if mode, exists := in.NetworkSettings.Settings["mode"]; exists {
+				params = append(params, fmt.Sprintf("mode=%s", mode))
+			}
+			if customHost, exists := in.NetworkSettings.Settings["custom_host"]; exists {
+				params = append(params, fmt.Sprintf("custom_host=%s", customHost))
+			}
+			if noGRPCHeader, exists := in.NetworkSettings.Settings["noGRPCHeader"]; exists {
+				if noGRPC, ok := noGRPCHeader.(bool); ok && noGRPC {
+					params = append(params, "noGRPCHeader=true")
+				}
+			}
+			if noSSEHeader, exists := in.NetworkSettings.Settings["noSSEHeader"]; exists {
+				if noSSE, ok := noSSEHeader.(bool); ok && noSSE {
+					params = append(params, "noSSEHeader=true")
+				}
+			}
 		case "kcp":
 			// KCP transport settings
-			if header, exists := node.NetworkSettings.Settings["header"]; exists {
+			if header, exists := in.NetworkSettings.Settings["header"]; exists {
 				if headerMap, ok := header.(map[string]interface{}); ok {
 					if headerType, exists := headerMap["type"]; exists {
 						params = append(params, fmt.Sprintf("headerType=%s", headerType))
@@ -535,15 +614,16 @@ func generateTrojanLink(node *database.Node, user *database.User, settings *data
 					}
 				}
 			}
-			if seed, exists := node.NetworkSettings.Settings["seed"]; exists {
+			if seed, exists := in.NetworkSettings.Settings["seed"]; exists {
 				params = append(params, fmt.Sprintf("seed=%s", seed))
 			}
 		case "tcp":
 			// TCP transport with optional HTTP header
-			if header, exists := node.NetworkSettings.Settings["header"]; exists {
+			if header, exists := in.NetworkSettings.Settings["header"]; exists {
 				if headerMap, ok := header.(map[string]interface{}); ok {
 					if headerType, exists := headerMap["type"]; exists && headerType == "http" {
 						// TCP with HTTP header obfuscation
+						params = append(params, "headerType=http")
 						if path, exists := headerMap["path"]; exists {
 							params = append(params, fmt.Sprintf("path=%s", path))
 						}
@@ -560,25 +640,29 @@ func generateTrojanLink(node *database.Node, user *database.User, settings *data
 		}
 	}
 	
-	return fmt.Sprintf("%s?%s#%s", baseURL, joinParams(params), node.ServerName)
-}
-
-func generateShadowsocksLink(node *database.Node, user *database.User, settings *database.Settings) string {
-	// Shadowsocks link format: ss://base64(method:password)@host:port#name
-	// Use the node's encryption method, not the user's method
-	auth := base64.StdEncoding.EncodeToString([]byte(node.Encryption + ":" + user.ShadowsocksPassword))
-	return fmt.Sprintf("ss://%s@%s:%d#%s", auth, settings.Host, node.ListeningPort, node.ServerName)
+	// Fragment hint, for the clients that fragment the connections by links
+	if fragment := utils.InboundFragment(in.Fragment, in.FragmentValue); fragment != nil {
+		params = append(params, fmt.Sprintf("fragment=%s", fragment))
+	}
+	
+	return fmt.Sprintf("%s?%s#%s", baseURL, joinParams(params), node.InboundName(in))
 }
 
-func generateUserUUID(user *database.User) string {
-	// Generate deterministic UUID based on user identity
-	// This ensures the same user always gets the same UUID
-	return fmt.Sprintf("%s-0000-0000-0000-000000000000", user.Identity[:8])
+// linkNetwork returns the network field of the links of the given inbound.
+// The nodes serve the http transport as TCP with HTTP header obfuscation, so its links carry the tcp network
+// with the http header type, like the TCP inbounds with the HTTP header.
+func linkNetwork(in *database.Inbound) string {
+	if in.NetworkSettings.Transport == "http" {
+		return "tcp"
+	}
+	return in.NetworkSettings.Transport
 }
 
-func generateTrojanPassword(user *database.User) string {
-	// Generate Trojan password based on user identity
-	return user.Identity
+func generateShadowsocksLink(node *database.Node, in *database.Inbound, user *database.User, settings *database.Settings) string {
+	// Shadowsocks link format: ss://base64(method:password)@host:port#name
+	// Use the node's encryption method, not the user's method
+	auth := base64.StdEncoding.EncodeToString([]byte(in.Encryption + ":" + user.ShadowsocksPassword))
+	return fmt.Sprintf("ss://%s@%s:%d#%s", auth, settings.Host, in.ListeningPort, node.InboundName(in))
 }
 
 func joinParams(params []string) string {
@@ -597,19 +681,59 @@ func ProfileRegenerate(coordinator *coordinator.Coordinator, d *database.Databas
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		var user *database.User
-		for _, u := range d.Content.Users {
-			if u.Identity == c.QueryParam("u") {
-				user = u
-			}
+		user := d.FindUserByIdentity(c.QueryParam("u"))
+		if user == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "Not found.",
+			})
 		}
+
+		request := CredentialsRotateRequest{Credentials: []string{
+			database.CredentialShadowsocks,
+			database.CredentialUUID,
+			database.CredentialTrojan,
+		}}
+		if err := request.rotate(d, user); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := d.Save(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		go coordinator.SyncConfigs()
+
+		return c.JSON(http.StatusOK, user)
+	}
+}
+
+func ProfileCredentialsRotate(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request CredentialsRotateRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		user := d.FindUserByIdentity(c.QueryParam("u"))
 		if user == nil {
 			return c.JSON(http.StatusNotFound, map[string]string{
 				"message": "Not found.",
 			})
 		}
 
-		user.ShadowsocksPassword = d.GenerateUserPassword()
+		if err := request.rotate(d, user); err != nil {
+			return errors.WithStack(err)
+		}
 
 		if err := d.Save(); err != nil {
 			return errors.WithStack(err)
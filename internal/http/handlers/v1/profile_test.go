@@ -0,0 +1,176 @@
+package v1
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/links"
+	"github.com/ebadidev/arch-manager/internal/subscription"
+)
+
+// linkTransports holds the network settings of the transports of ProtocolsList, and the TCP HTTP header.
+var linkTransports = map[string]database.NetworkConfig{
+	"tcp": {Transport: "tcp"},
+	"tcp-http-header": {Transport: "tcp", Settings: map[string]interface{}{
+		"header": map[string]interface{}{"type": "http", "path": "/tcp", "host": []interface{}{"tcp.example.com"}},
+	}},
+	"http": {Transport: "http", Settings: map[string]interface{}{
+		"path": "/http", "host": []interface{}{"http.example.com"},
+	}},
+	"ws": {Transport: "ws", Settings: map[string]interface{}{
+		"path": "/ws", "host": "ws.example.com",
+	}},
+	"grpc": {Transport: "grpc", Settings: map[string]interface{}{
+		"serviceName": "service", "authority": "grpc.example.com",
+	}},
+	"kcp": {Transport: "kcp", Settings: map[string]interface{}{
+		"seed": "seed", "header": map[string]interface{}{"type": "srtp", "domain": "kcp.example.com"},
+	}},
+	"httpupgrade": {Transport: "httpupgrade", Settings: map[string]interface{}{
+		"path": "/upgrade", "host": "upgrade.example.com",
+	}},
+	"xhttp": {Transport: "xhttp", Settings: map[string]interface{}{
+		"path": "/xhttp", "host": "xhttp.example.com",
+	}},
+}
+
+// linkSecurities holds the securities each protocol has links for.
+var linkSecurities = map[string][]string{
+	"shadowsocks": {"none"},
+	"vmess":       {"none", "tls"},
+	"vless":       {"none", "tls", "reality"},
+	"trojan":      {"tls"},
+}
+
+func generateLink(node *database.Node, in *database.Inbound, user *database.User, settings *database.Settings) string {
+	switch in.Protocol {
+	case "vmess":
+		return generateVMessLink(node, in, user, settings)
+	case "vless":
+		return generateVLESSLink(node, in, user, settings)
+	case "trojan":
+		return generateTrojanLink(node, in, user, settings)
+	default:
+		return generateShadowsocksLink(node, in, user, settings)
+	}
+}
+
+func TestLinkRoundTrip(t *testing.T) {
+	user := &database.User{UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", TrojanPassword: "trojan", ShadowsocksPassword: "ss"}
+	settings := &database.Settings{Host: "example.com"}
+
+	for protocol, securities := range linkSecurities {
+		for name, network := range linkTransports {
+			if protocol == "shadowsocks" && name != "tcp" {
+				continue
+			}
+			for _, security := range securities {
+				t.Run(fmt.Sprintf("%s/%s/%s", protocol, name, security), func(t *testing.T) {
+					in := &database.Inbound{
+						Tag:             protocol,
+						Protocol:        protocol,
+						ListeningPort:   443,
+						Encryption:      "none",
+						NetworkSettings: network,
+						Security:        security,
+						Fragment:        protocol == "vless",
+						FragmentValue:   "tlshello,100-200,10-20",
+					}
+					switch protocol {
+					case "vmess":
+						in.Encryption = "auto"
+					case "shadowsocks":
+						in.Encryption = "aes-128-gcm"
+					}
+					switch security {
+					case "tls":
+						in.SecuritySettings.TLS = &database.TLSConfig{
+							SNI:         "tls.example.com",
+							Fingerprint: "chrome",
+							ALPN:        []string{"h2", "http/1.1"},
+						}
+					case "reality":
+						in.SecuritySettings.Reality = &database.RealityConfig{
+							Fingerprint: "chrome",
+							ServerNames: []string{"reality.example.com"},
+							PublicKey:   "public-key",
+							ShortIDs:    []string{"abcd"},
+							SpiderX:     "/",
+						}
+					}
+					node := &database.Node{ServerName: "Node", Inbounds: []*database.Inbound{in}}
+
+					link := generateLink(node, in, user, settings)
+					if link == "" {
+						t.Fatal("got no link")
+					}
+					parsed, err := links.Parse(link)
+					if err != nil {
+						t.Fatalf("cannot parse %s: %v", link, err)
+					}
+					if got := generateLink(parsed, parsed.Inbounds[0], user, settings); got != link {
+						t.Errorf("got %s, want %s", got, link)
+					}
+
+					pin := parsed.Inbounds[0]
+					if pin.Protocol != protocol || pin.Security != security || pin.ListeningPort != in.ListeningPort {
+						t.Errorf("got %s, %s and %d", pin.Protocol, pin.Security, pin.ListeningPort)
+					}
+					want := network.Transport
+					if name == "tcp-http-header" {
+						want = "http"
+					}
+					if pin.NetworkSettings.Transport != want {
+						t.Errorf("got the %s transport, want %s", pin.NetworkSettings.Transport, want)
+					}
+					if want == "http" && (pin.NetworkSettings.Settings["path"] == nil || pin.NetworkSettings.Settings["host"] == nil) {
+						t.Errorf("got %v, want the path and the host", pin.NetworkSettings.Settings)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestConnectionHops(t *testing.T) {
+	in := &database.Inbound{
+		Tag:             "vless",
+		Protocol:        "vless",
+		ServerPort:      "2000:2002",
+		ListeningPort:   2001,
+		Encryption:      "none",
+		NetworkSettings: database.NetworkConfig{Transport: "tcp"},
+		Security:        "none",
+	}
+	user := &database.User{UUID: "b831381d-6324-4d53-ad4f-8cda48b30811"}
+	d := &database.Database{Content: &database.Content{
+		Settings: &database.Settings{Host: "example.com"},
+		Users:    []*database.User{user},
+		Nodes:    []*database.Node{{Id: 1, ServerName: "Node", Inbounds: []*database.Inbound{in}}},
+	}}
+
+	connections := generateConnectionInfo(d, user)
+	if len(connections) != 3 {
+		t.Fatalf("got %d connections, want one on each port of the range", len(connections))
+	}
+	for i, port := range []int{2001, 2000, 2002} {
+		parsed, err := links.Parse(connections[i].Link)
+		if err != nil {
+			t.Fatalf("cannot parse %s: %v", connections[i].Link, err)
+		}
+		if connections[i].Port != port || parsed.Inbounds[0].ListeningPort != port {
+			t.Errorf("got the link %s on %d, want %d", connections[i].Link, connections[i].Port, port)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, p := range subscription.Proxies(d, user) {
+		names[p.Name] = true
+	}
+	for _, name := range []string{"Node", "Node:2000", "Node:2002"} {
+		if !names[name] {
+			t.Errorf("got the proxies %v, want %s", names, name)
+		}
+	}
+}
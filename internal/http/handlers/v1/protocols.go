@@ -3,11 +3,17 @@ package v1
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/ebadidev/arch-manager/internal/coordinator"
 	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/importer"
+	"github.com/ebadidev/arch-manager/internal/links"
+	"github.com/ebadidev/arch-manager/internal/writer"
+	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/curve25519"
 )
@@ -21,7 +27,7 @@ func ProtocolsList(d *database.Database) echo.HandlerFunc {
 			"securities": []string{"none", "tls", "reality"},
 			"core_types": []string{"xray"},
 			"cert_modes": []string{"http", "file", "dns", "none"},
-			"encryption_options": d.Content.Settings.EncryptionOptions,
+			"encryption_options": d.Settings().EncryptionOptions,
 		})
 	}
 }
@@ -34,10 +40,8 @@ func NodeConfigGet(d *database.Database) echo.HandlerFunc {
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 		
-		for _, node := range d.Content.Nodes {
-			if node.Id == parseNodeId(nodeId) {
-				return c.JSON(http.StatusOK, node)
-			}
+		if node := d.FindNode(parseId(nodeId)); node != nil {
+			return c.JSON(http.StatusOK, node.Redacted())
 		}
 		
 		return c.JSON(http.StatusNotFound, map[string]string{
@@ -61,33 +65,38 @@ func NodeConfigUpdate(coordinator *coordinator.Coordinator, d *database.Database
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 		
-		for i, node := range d.Content.Nodes {
-			if node.Id == parseNodeId(nodeId) {
-				// Preserve existing basic node connection fields
-				config.Id = node.Id
-				config.Host = node.Host
-				config.HttpToken = node.HttpToken
-				config.HttpPort = node.HttpPort
-				config.Usage = node.Usage
-				config.UsageBytes = node.UsageBytes
-				config.PushStatus = node.PushStatus
-				config.PullStatus = node.PullStatus
-				config.PushedAt = node.PushedAt
-				config.PulledAt = node.PulledAt
-				
-				d.Content.Nodes[i] = &config
-				
-				if err := d.Save(); err != nil {
-					return c.JSON(http.StatusInternalServerError, map[string]string{
-						"message": "Failed to save configuration",
-					})
-				}
-				
-				// Trigger configuration sync
-				go coordinator.SyncConfigs()
-				
-				return c.JSON(http.StatusOK, config)
+		if node := d.FindNode(parseId(nodeId)); node != nil {
+			// Preserve existing basic node connection fields
+			config.Id = node.Id
+			config.Host = node.Host
+			config.HttpToken = node.HttpToken
+			config.HttpPort = node.HttpPort
+			config.Usage = node.Usage
+			config.UsageBytes = node.UsageBytes
+			config.PushStatus = node.PushStatus
+			config.PullStatus = node.PullStatus
+			config.PushedAt = node.PushedAt
+			config.PulledAt = node.PulledAt
+			config.ConfigError = node.ConfigError
+			config.RestoreSecrets(node)
+			if err := writer.CheckNode(&config); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": fmt.Sprintf("Invalid node settings: %v", err.Error()),
+				})
+			}
+			
+			d.ReplaceNode(&config)
+			
+			if err := d.Save(); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"message": "Failed to save configuration",
+				})
 			}
+			
+			// Trigger configuration sync
+			go coordinator.SyncConfigs()
+			
+			return c.JSON(http.StatusOK, config.Redacted())
 		}
 		
 		return c.JSON(http.StatusNotFound, map[string]string{
@@ -96,6 +105,68 @@ func NodeConfigUpdate(coordinator *coordinator.Coordinator, d *database.Database
 	}
 }
 
+type NodeConfigFromLinkRequest struct {
+	Link string `json:"link" validate:"required"`
+}
+
+// NodeConfigFromLink parses a client link into a node configuration, without saving it.
+// The admin completes the fields that links do not carry and creates the node with NodeConfigCreate.
+func NodeConfigFromLink() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var r NodeConfigFromLinkRequest
+		if err := c.Bind(&r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		node, err := links.Parse(r.Link)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Cannot parse the link: %v", err.Error()),
+			})
+		}
+
+		return c.JSON(http.StatusOK, node)
+	}
+}
+
+type NodeConfigImportRequest struct {
+	Config json.RawMessage `json:"config" validate:"required"`
+}
+
+// NodeConfigImport maps the inbounds of an Xray server configuration to node configurations, without saving them.
+// The settings that the nodes cannot hold are listed in the response.
+func NodeConfigImport() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var r NodeConfigImportRequest
+		if err := c.Bind(&r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		report, err := importer.XrayConfig(r.Config)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Cannot import the configuration: %v", err.Error()),
+			})
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
 // NodeConfigCreate creates a new node with full configuration
 func NodeConfigCreate(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -110,7 +181,7 @@ func NodeConfigCreate(coordinator *coordinator.Coordinator, d *database.Database
 		defer d.Locker.Unlock()
 		
 		// Check node limit
-		if len(d.Content.Nodes) > 5 {
+		if len(d.Nodes()) > 5 {
 			return c.JSON(http.StatusForbidden, map[string]string{
 				"message": "Cannot add more nodes!",
 			})
@@ -118,13 +189,18 @@ func NodeConfigCreate(coordinator *coordinator.Coordinator, d *database.Database
 		
 		// Generate new node ID
 		config.Id = d.GenerateNodeId()
+		if err := writer.CheckNode(&config); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Invalid node settings: %v", err.Error()),
+			})
+		}
 		
 		// Set default status
 		config.PushStatus = database.NodeStatusProcessing
 		config.PullStatus = database.NodeStatusProcessing
 		
 		// Add node to database
-		d.Content.Nodes = append(d.Content.Nodes, &config)
+		d.AddNode(&config)
 		
 		if err := d.Save(); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{
@@ -135,13 +211,13 @@ func NodeConfigCreate(coordinator *coordinator.Coordinator, d *database.Database
 		// Trigger configuration sync
 		go coordinator.SyncConfigs()
 		
-		return c.JSON(http.StatusCreated, config)
+		return c.JSON(http.StatusCreated, config.Redacted())
 	}
 }
 
-// Helper function to parse node ID
-func parseNodeId(nodeIdStr string) int {
-	if id, err := strconv.Atoi(nodeIdStr); err == nil {
+// Helper function to parse entity (user, node) IDs
+func parseId(idStr string) int {
+	if id, err := strconv.Atoi(idStr); err == nil {
 		return id
 	}
 	return 0
@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+// QRRequest holds the image options of the QR code endpoints.
+type QRRequest struct {
+	Format string `query:"format" validate:"omitempty,oneof=png svg"`
+	Size   int    `query:"size" validate:"omitempty,min=64,max=2048"`
+	Level  string `query:"level" validate:"omitempty,oneof=L M Q H"`
+}
+
+// respond renders the given content as a QR code image, a 256px PNG with the M error correction level by default.
+func (r *QRRequest) respond(c echo.Context, content string) error {
+	size, level := r.Size, r.Level
+	if size == 0 {
+		size = 256
+	}
+	if level == "" {
+		level = "M"
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if r.Format == "svg" {
+		svg, err := utils.QRSVG(content, level, size)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return c.Blob(http.StatusOK, "image/svg+xml", []byte(svg))
+	}
+
+	png, err := utils.QRPNG(content, level, size)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return c.Blob(http.StatusOK, "image/png", png)
+}
+
+// subscriptionURL returns the subscription URL of the given user, under the public URL of the subscriptions,
+// or else on the host of the settings and the port of the HTTP server.
+// The request is not trusted for it, as its Host header is up to the client and its scheme is lost behind proxies.
+func subscriptionURL(cfg *config.Config, settings *database.Settings, user *database.User) string {
+	base := strings.TrimSuffix(cfg.Subscription.URL, "/")
+	if base == "" {
+		base = "http://" + net.JoinHostPort(settings.Host, strconv.Itoa(cfg.HttpServer.Port))
+	}
+	return base + "/sub/" + user.Identity
+}
+
+// ProfileConnectionQR renders the link of a connection of the profile, by its index in the connections, as a QR code.
+func ProfileConnectionQR(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var r QRRequest
+		if err := c.Bind(&r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the query parameters.",
+			})
+		}
+		if err := validator.New().Struct(r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		user := d.FindUserByIdentity(c.QueryParam("u"))
+		if user == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "Not found.",
+			})
+		}
+
+		connections := generateConnectionInfo(d, user)
+		index, err := strconv.Atoi(c.Param("index"))
+		if err != nil || index < 0 || index >= len(connections) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "Not found.",
+			})
+		}
+
+		return r.respond(c, connections[index].Link)
+	}
+}
+
+// ProfileSubscriptionQR renders the subscription URL of the profile as a QR code.
+func ProfileSubscriptionQR(cfg *config.Config, d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var r QRRequest
+		if err := c.Bind(&r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the query parameters.",
+			})
+		}
+		if err := validator.New().Struct(r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		user := d.FindUserByIdentity(c.QueryParam("u"))
+		if user == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "Not found.",
+			})
+		}
+
+		return r.respond(c, subscriptionURL(cfg, d.Settings(), user))
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 func SettingsShow(d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		return c.JSON(http.StatusOK, d.Content.Settings)
+		return c.JSON(http.StatusOK, d.Settings().Redacted())
 	}
 }
 
@@ -34,7 +34,11 @@ func SettingsUpdate(coordinator *coordinator.Coordinator, d *database.Database)
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		d.Content.Settings = &r
+		if r.AdminPassword == database.Redacted {
+			r.AdminPassword = d.Settings().AdminPassword
+		}
+
+		d.UpdateSettings(&r)
 
 		if err := d.Save(); err != nil {
 			return errors.WithStack(err)
@@ -42,7 +46,7 @@ func SettingsUpdate(coordinator *coordinator.Coordinator, d *database.Database)
 
 		go coordinator.SyncConfigs()
 
-		return c.JSON(http.StatusOK, r)
+		return c.JSON(http.StatusOK, r.Redacted())
 	}
 }
 
@@ -25,9 +25,9 @@ type StatsResponse struct {
 
 func makeStatsResponse(d *database.Database) *StatsResponse {
 	return &StatsResponse{
-		TotalUsageResetAt: d.Content.Stats.TotalUsageResetAt,
-		TotalUsage:        d.Content.Stats.TotalUsage,
-		TotalUsers:        len(d.Content.Users),
+		TotalUsageResetAt: d.Stats().TotalUsageResetAt,
+		TotalUsage:        d.Stats().TotalUsage,
+		TotalUsers:        len(d.Users()),
 		ActiveUsers:       d.CountActiveUsers(),
 	}
 }
@@ -56,9 +56,10 @@ func StatsUpdatePartial(d *database.Database) echo.HandlerFunc {
 		defer d.Locker.Unlock()
 
 		if request.TotalUsage != nil {
-			d.Content.Stats.TotalUsage = *request.TotalUsage
-			d.Content.Stats.TotalUsageBytes = utils.GB2Bytes(*request.TotalUsage)
-			d.Content.Stats.TotalUsageResetAt = time.Now().UnixMilli()
+			stats := d.Stats()
+			stats.TotalUsage = *request.TotalUsage
+			stats.TotalUsageBytes = utils.GB2Bytes(*request.TotalUsage)
+			stats.TotalUsageResetAt = time.Now().UnixMilli()
 		}
 
 		if err := d.Save(); err != nil {
@@ -0,0 +1,169 @@
+package v1
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/subscription"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/ebadidev/arch-manager/internal/writer"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	SubscriptionFormatBase64  = "base64"   // Base64-encoded list of links, one per line
+	SubscriptionFormatLinks   = "links"    // Plain list of links, one per line
+	SubscriptionFormatClash   = "clash"    // Clash Meta (Mihomo) YAML profile
+	SubscriptionFormatSingBox = "sing-box" // sing-box JSON configuration
+	SubscriptionFormatXray    = "xray"     // Xray JSON client configuration
+	SubscriptionFormatPage    = "page"     // Redirect to the profile page
+)
+
+// subscriptionUserAgents maps the User-Agent prefixes (lowercase) of client apps to the format they expect.
+var subscriptionUserAgents = []struct {
+	prefix string
+	format string
+}{
+	{"v2rayn", SubscriptionFormatBase64},
+	{"hiddify", SubscriptionFormatSingBox},
+	{"streisand", SubscriptionFormatBase64},
+	{"nekobox", SubscriptionFormatBase64},
+	{"nekoray", SubscriptionFormatBase64},
+	{"shadowrocket", SubscriptionFormatBase64},
+	{"clash", SubscriptionFormatClash},
+	{"mihomo", SubscriptionFormatClash},
+	{"stash", SubscriptionFormatClash},
+	{"flclash", SubscriptionFormatClash},
+	{"sing-box", SubscriptionFormatSingBox},
+	{"sfa/", SubscriptionFormatSingBox},
+	{"sfi/", SubscriptionFormatSingBox},
+	{"sfm/", SubscriptionFormatSingBox},
+	{"sft/", SubscriptionFormatSingBox},
+}
+
+// subscriptionFormat returns the format of the `format` query parameter, or else the format expected by the User-Agent.
+// Browsers are sent to the profile page, and unknown clients get the base64 list.
+func subscriptionFormat(c echo.Context) string {
+	if format := c.QueryParam("format"); format != "" {
+		return format
+	}
+
+	agent := strings.ToLower(c.Request().UserAgent())
+	for _, a := range subscriptionUserAgents {
+		if strings.HasPrefix(agent, a.prefix) {
+			return a.format
+		}
+	}
+	if strings.HasPrefix(agent, "mozilla/") {
+		return SubscriptionFormatPage
+	}
+	return SubscriptionFormatBase64
+}
+
+// setSubscriptionHeaders sets the headers that client apps read the usage, expiry, title and refresh interval from.
+func setSubscriptionHeaders(c echo.Context, cfg *config.Config, d *database.Database, user *database.User) {
+	ratio := d.Settings().TrafficRatio
+
+	var expire int64
+	if user.ExpiresAt > 0 {
+		expire = user.ExpiresAt / 1000
+	}
+	c.Response().Header().Set("Subscription-Userinfo", fmt.Sprintf(
+		"upload=0; download=%d; total=%d; expire=%d",
+		int64(float64(user.UsageBytes)*ratio),
+		utils.GB2Bytes(user.Quota*ratio),
+		expire,
+	))
+
+	title := cfg.Subscription.Title
+	if title == "" {
+		title = user.Name
+	}
+	c.Response().Header().Set("Profile-Title", "base64:"+base64.StdEncoding.EncodeToString([]byte(title)))
+	c.Response().Header().Set("Profile-Update-Interval", fmt.Sprint(cfg.Subscription.UpdateInterval))
+	c.Response().Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+}
+
+// attachment returns the Content-Disposition of a download with the given file name, quoted and encoded as needed.
+func attachment(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+func Subscription(cfg *config.Config, w *writer.Writer, d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		// Retired identities keep their subscription during the grace period, with the old links only.
+		identity := c.Param("identity")
+		user, retired := d.FindUserByIdentity(identity), false
+		if user == nil {
+			if user = d.FindUserByRetiredIdentity(identity, time.Now()); user != nil {
+				user, retired = user.Retiring(identity, time.Now()), true
+			}
+		}
+		if user == nil {
+			return c.String(http.StatusNotFound, "Not found.")
+		}
+
+		format := subscriptionFormat(c)
+		if format == SubscriptionFormatPage {
+			if retired {
+				return c.String(http.StatusNotFound, "Not found.")
+			}
+			return c.Redirect(http.StatusFound, "/profile?u="+url.QueryEscape(user.Identity))
+		}
+
+		setSubscriptionHeaders(c, cfg, d, user)
+
+		switch format {
+		case SubscriptionFormatBase64, SubscriptionFormatLinks:
+			var links []string
+			for _, connection := range generateConnectionInfo(d, user) {
+				links = append(links, connection.Link)
+			}
+			content := strings.Join(links, "\n")
+			if format == SubscriptionFormatBase64 {
+				content = base64.StdEncoding.EncodeToString([]byte(content))
+			}
+			return c.String(http.StatusOK, content)
+		case SubscriptionFormatClash:
+			path := cfg.Env.DefaultClashPath
+			if utils.FileExist(cfg.Env.LocalClashPath) {
+				path = cfg.Env.LocalClashPath
+			}
+			template, err := os.ReadFile(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			content, err := subscription.Clash(subscription.Proxies(d, user), template)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			c.Response().Header().Set("Content-Disposition", attachment(user.Name+".yaml"))
+			return c.Blob(http.StatusOK, "text/yaml; charset=utf-8", content)
+		case SubscriptionFormatSingBox:
+			templates := d.Settings().SingBox
+			content, err := subscription.SingBox(subscription.Proxies(d, user), templates.DNS, templates.Route)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			c.Response().Header().Set("Content-Disposition", attachment(user.Name+".json"))
+			return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, content)
+		case SubscriptionFormatXray:
+			c.Response().Header().Set("Content-Disposition", attachment(user.Name+".json"))
+			return c.JSONPretty(http.StatusOK, w.ClientConfig(user), "  ")
+		default:
+			return c.String(http.StatusBadRequest, "Unknown subscription format.")
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net/http"
 	"slices"
-	"strconv"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -22,6 +21,37 @@ type UsersStoreRequest struct {
 	Enabled bool    `json:"enabled"`
 	Quota   float64 `json:"quota" validate:"min=0"`
 	Usage   float64 `json:"usage"`
+	// ResetStrategy defaults to the reset policy of the settings for new users, and is kept by updates when empty.
+	ResetStrategy string `json:"reset_strategy" validate:"omitempty,oneof=none daily weekly monthly yearly"`
+	// Expiry is either an absolute time (unix milliseconds, 0 for never) or a duration ("720h", "30d"),
+	// which starts now or, with StartOnFirstUse, at the first traffic of the user.
+	// Updates keep the current expiry when neither is given.
+	ExpiresAt       *int64 `json:"expires_at" validate:"omitempty,min=0,excluded_with=ExpiresIn"`
+	ExpiresIn       string `json:"expires_in"`
+	StartOnFirstUse bool   `json:"start_on_first_use" validate:"excluded_without=ExpiresIn"`
+}
+
+// applyExpiry sets the expiry of the given user from the request.
+func (r *UsersStoreRequest) applyExpiry(user *database.User, now time.Time) error {
+	if r.ExpiresAt != nil {
+		user.ExpiresAt, user.ExpiresAfter = *r.ExpiresAt, 0
+	}
+	if r.ExpiresIn == "" {
+		return nil
+	}
+
+	duration, err := utils.ParseDuration(r.ExpiresIn)
+	if err != nil || duration <= 0 {
+		return errors.New("The expiry duration is invalid.")
+	}
+	if !r.StartOnFirstUse {
+		user.ExpiresAt, user.ExpiresAfter = now.Add(duration).UnixMilli(), 0
+	} else if user.FirstUsedAt > 0 {
+		user.ExpiresAt, user.ExpiresAfter = user.FirstUsedAt+duration.Milliseconds(), 0
+	} else {
+		user.ExpiresAt, user.ExpiresAfter = 0, duration.Milliseconds()
+	}
+	return nil
 }
 
 type UsersUpdateRequest struct {
@@ -35,7 +65,7 @@ type UsersUpdatePartialRequest struct {
 
 func UsersIndex(d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		return c.JSON(http.StatusOK, d.Content.Users)
+		return c.JSON(http.StatusOK, d.Users())
 	}
 }
 
@@ -56,23 +86,21 @@ func UsersStore(coordinator *coordinator.Coordinator, d *database.Database, l *l
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		if len(d.Content.Users) >= config.MaxUsersCount {
+		if len(d.Users()) >= config.MaxUsersCount {
 			return c.JSON(http.StatusForbidden, map[string]string{
 				"message": "You have already reached the maximum number of users.",
 			})
 		}
-		if len(d.Content.Users) >= config.FreeUsersCount && !l.Licensed() {
+		if len(d.Users()) >= config.FreeUsersCount && !l.Licensed() {
 			return c.JSON(http.StatusForbidden, map[string]string{
 				"message": "You cannot add more users without license.",
 			})
 		}
 
-		for _, u := range d.Content.Users {
-			if u.Name == request.Name {
-				return c.JSON(http.StatusBadRequest, map[string]string{
-					"message": "The name is already taken.",
-				})
-			}
+		if d.FindUserByName(request.Name) != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "The name is already taken.",
+			})
 		}
 
 		user := &database.User{}
@@ -81,13 +109,25 @@ func UsersStore(coordinator *coordinator.Coordinator, d *database.Database, l *l
 		user.CreatedAt = time.Now().UnixMilli()
 		user.ShadowsocksMethod = config.ShadowsocksMethod
 		user.ShadowsocksPassword = d.GenerateUserPassword()
+		user.UUID = d.GenerateUserUUID()
+		user.TrojanPassword = d.GenerateUserTrojanPassword()
 		user.Usage = request.Usage
 		user.UsageBytes = utils.GB2Bytes(request.Usage)
+		user.UsageResetAt = user.CreatedAt
+		user.ResetStrategy = request.ResetStrategy
+		if user.ResetStrategy == "" {
+			user.ResetStrategy = d.Settings().DefaultResetStrategy()
+		}
 		user.Name = request.Name
 		user.Quota = request.Quota
 		user.Enabled = request.Enabled
+		if err := request.applyExpiry(user, time.Now()); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
 
-		d.Content.Users = append(d.Content.Users, user)
+		d.AddUser(user)
 
 		if err := d.Save(); err != nil {
 			return errors.WithStack(err)
@@ -116,25 +156,27 @@ func UsersUpdate(coordinator *coordinator.Coordinator, d *database.Database) ech
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		var user *database.User
-		for i, u := range d.Content.Users {
-			if strconv.Itoa(u.Id) == c.Param("id") {
-				user = d.Content.Users[i]
-			} else {
-				if u.Name == request.Name {
-					return c.JSON(http.StatusBadRequest, map[string]string{
-						"message": "The name is already taken.",
-					})
-				}
-			}
-		}
+		user := d.FindUser(parseId(c.Param("id")))
 		if user == nil {
 			return c.NoContent(http.StatusNotFound)
 		}
+		if u := d.FindUserByName(request.Name); u != nil && u.Id != user.Id {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "The name is already taken.",
+			})
+		}
 
+		if err := request.applyExpiry(user, time.Now()); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": err.Error(),
+			})
+		}
 		user.Name = request.Name
 		user.Quota = request.Quota
 		user.Enabled = request.Enabled
+		if request.ResetStrategy != "" {
+			user.ResetStrategy = request.ResetStrategy
+		}
 
 		if err := d.Save(); err != nil {
 			return errors.WithStack(err)
@@ -163,17 +205,14 @@ func UsersUpdatePartial(coordinator *coordinator.Coordinator, d *database.Databa
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		var user *database.User
-		for i, u := range d.Content.Users {
-			if strconv.Itoa(u.Id) == c.Param("id") {
-				user = d.Content.Users[i]
-			}
-		}
+		user := d.FindUser(parseId(c.Param("id")))
 		if user == nil {
 			return c.NoContent(http.StatusNotFound)
 		}
 
-		if request.Usage != nil {
+		if request.Usage != nil && *request.Usage == 0 {
+			user.ResetUsage()
+		} else if request.Usage != nil {
 			user.Usage = *request.Usage
 			user.UsageBytes = utils.GB2Bytes(*request.Usage)
 		}
@@ -208,8 +247,10 @@ func UsersUpdatePartialBatch(coordinator *coordinator.Coordinator, d *database.D
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		for _, user := range d.Content.Users {
-			if request.Usage != nil {
+		for _, user := range d.Users() {
+			if request.Usage != nil && *request.Usage == 0 {
+				user.ResetUsage()
+			} else if request.Usage != nil {
 				user.Usage = *request.Usage
 				user.UsageBytes = utils.GB2Bytes(*request.Usage)
 			}
@@ -233,15 +274,11 @@ func UsersDelete(coordinator *coordinator.Coordinator, d *database.Database) ech
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		for i, u := range d.Content.Users {
-			if strconv.Itoa(u.Id) == c.Param("id") {
-				d.Content.Users = slices.Delete(d.Content.Users, i, i+1)
-				if err := d.Save(); err != nil {
-					return errors.WithStack(err)
-				}
-				go coordinator.SyncConfigs()
-				break
+		if d.DeleteUser(parseId(c.Param("id"))) {
+			if err := d.Save(); err != nil {
+				return errors.WithStack(err)
 			}
+			go coordinator.SyncConfigs()
 		}
 
 		return c.NoContent(http.StatusNoContent)
@@ -266,22 +303,62 @@ func UsersDeleteBatch(coordinator *coordinator.Coordinator, d *database.Database
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		var newUsers []*database.User
+		d.DeleteUsers(func(u *database.User) bool {
+			return enabled == nil || u.Enabled == *enabled
+		})
 
-		if enabled != nil {
-			for _, u := range d.Content.Users {
-				if u.Enabled != *enabled {
-					newUsers = append(newUsers, u)
-				}
-			}
-			d.Content.Users = newUsers
+		if err := d.Save(); err != nil {
+			return errors.WithStack(err)
 		}
 
-		if newUsers == nil {
-			newUsers = []*database.User{}
+		go coordinator.SyncConfigs()
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+type CredentialsRotateRequest struct {
+	Credentials []string `json:"credentials" validate:"required,min=1,dive,oneof=shadowsocks uuid trojan identity"`
+	GraceHours  int      `json:"grace_hours" validate:"min=0,max=720"`
+}
+
+// rotate replaces the requested credentials of the given user, keeping the previous ones for the grace period.
+func (r *CredentialsRotateRequest) rotate(d *database.Database, user *database.User) error {
+	now := time.Now()
+	grace := time.Duration(r.GraceHours) * time.Hour
+	for _, kind := range slices.Compact(slices.Sorted(slices.Values(r.Credentials))) {
+		if err := d.RotateCredential(user, kind, grace, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UsersCredentialsRotate(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request CredentialsRotateRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
 		}
 
-		d.Content.Users = newUsers
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		user := d.FindUser(parseId(c.Param("id")))
+		if user == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		if err := request.rotate(d, user); err != nil {
+			return errors.WithStack(err)
+		}
 
 		if err := d.Save(); err != nil {
 			return errors.WithStack(err)
@@ -289,6 +366,109 @@ func UsersDeleteBatch(coordinator *coordinator.Coordinator, d *database.Database
 
 		go coordinator.SyncConfigs()
 
-		return c.NoContent(http.StatusNoContent)
+		return c.JSON(http.StatusOK, user)
+	}
+}
+
+type UsersUsageRequest struct {
+	From int64  `query:"from" validate:"min=0"`
+	To   int64  `query:"to" validate:"min=0"`
+	Step string `query:"step" validate:"omitempty,oneof=minute hour day"`
+}
+
+type UsageSeriesResponse struct {
+	Step       string                `json:"step"`
+	From       int64                 `json:"from"`
+	To         int64                 `json:"to"`
+	TotalBytes int64                 `json:"total_bytes"`
+	Points     []database.UsagePoint `json:"points"`
+}
+
+// maxUsagePoints limits the buckets of a usage series response.
+const maxUsagePoints = 1500
+
+// makeUsageSeriesResponse returns the usage series of the user with bytes multiplied by the given ratio.
+func makeUsageSeriesResponse(d *database.Database, userId int, step string, from, to time.Time, ratio float64) *UsageSeriesResponse {
+	points := d.UsageSeries(userId, step, from, to)
+
+	var total int64
+	for i := range points {
+		points[i].B = int64(float64(points[i].B) * ratio)
+		total += points[i].B
+	}
+
+	return &UsageSeriesResponse{
+		Step:       step,
+		From:       from.UnixMilli(),
+		To:         to.UnixMilli(),
+		TotalBytes: total,
+		Points:     points,
+	}
+}
+
+func UsersUsage(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var r UsersUsageRequest
+		if err := c.Bind(&r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request query.",
+			})
+		}
+		if err := validator.New().Struct(r); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		to := time.Now()
+		if r.To > 0 {
+			to = time.UnixMilli(r.To)
+		}
+		from := to.Add(-24 * time.Hour)
+		if r.From > 0 {
+			from = time.UnixMilli(r.From)
+		}
+		if !from.Before(to) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Validation error: from must be before to.",
+			})
+		}
+
+		step := r.Step
+		if step == "" {
+			switch span := to.Sub(from); {
+			case span <= 3*time.Hour:
+				step = database.UsageStepMinute
+			case span <= 14*24*time.Hour:
+				step = database.UsageStepHour
+			default:
+				step = database.UsageStepDay
+			}
+		}
+
+		var buckets time.Duration
+		switch step {
+		case database.UsageStepMinute:
+			buckets = to.Sub(from) / time.Minute
+		case database.UsageStepHour:
+			buckets = to.Sub(from) / time.Hour
+		default:
+			buckets = to.Sub(from) / (24 * time.Hour)
+		}
+		if buckets > maxUsagePoints {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: the range is more than %d %s buckets.", maxUsagePoints, step),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		user := d.FindUser(parseId(c.Param("id")))
+		if user == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		return c.JSON(http.StatusOK, makeUsageSeriesResponse(d, user.Id, step, from, to, 1))
 	}
 }
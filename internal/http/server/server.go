@@ -44,6 +44,7 @@ func (s *Server) Run() {
 
 	s.e.Static("/", "web")
 	s.e.GET("/profile", pages.Profile(s.config, s.database))
+	s.e.GET("/sub/:identity", v1.Subscription(s.config, s.writer, s.database))
 	s.e.GET("/admin/node-config", func(c echo.Context) error {
 		return c.File("web/admin-node-config.html")
 	})
@@ -53,10 +54,13 @@ func (s *Server) Run() {
 
 	g1.GET("/profile", v1.ProfileShow(s.database))
 	g1.POST("/profile/links/regenerate", v1.ProfileRegenerate(s.coordinator, s.database))
+	g1.POST("/profile/credentials/rotate", v1.ProfileCredentialsRotate(s.coordinator, s.database))
+	g1.GET("/profile/connections/:index/qr", v1.ProfileConnectionQR(s.database))
+	g1.GET("/profile/subscription/qr", v1.ProfileSubscriptionQR(s.config, s.database))
 
 	g2 := s.e.Group("/v1")
 	g2.Use(middleware.Authorize(func() string {
-		return s.database.Content.Settings.AdminPassword
+		return s.database.Settings().AdminPassword
 	}))
 
 	g2.GET("/users", v1.UsersIndex(s.database))
@@ -66,6 +70,10 @@ func (s *Server) Run() {
 	g2.PATCH("/users/:id", v1.UsersUpdatePartial(s.coordinator, s.database))
 	g2.DELETE("/users/:id", v1.UsersDelete(s.coordinator, s.database))
 	g2.DELETE("/users", v1.UsersDeleteBatch(s.coordinator, s.database))
+	g2.GET("/users/:id/usage", v1.UsersUsage(s.database))
+	g2.POST("/users/:id/credentials/rotate", v1.UsersCredentialsRotate(s.coordinator, s.database))
+	g2.GET("/users/cards", v1.UsersCards(s.config, s.database))
+	g2.GET("/users/:id/card", v1.UsersCards(s.config, s.database))
 
 	g2.GET("/nodes", v1.NodesIndex(s.database))
 	g2.POST("/nodes", v1.NodesStore(s.coordinator, s.database))
@@ -78,10 +86,14 @@ func (s *Server) Run() {
 	// Node configuration management endpoints
 	g2.GET("/nodes/:id/config", v1.NodeConfigGet(s.database))
 	g2.PUT("/nodes/:id/config", v1.NodeConfigUpdate(s.coordinator, s.database))
+	g2.POST("/nodes/:id/config/validate", v1.NodeConfigValidate(s.coordinator, s.writer, s.database))
 	g2.POST("/nodes/config", v1.NodeConfigCreate(s.coordinator, s.database))
+	g2.POST("/nodes/config/from-link", v1.NodeConfigFromLink())
+	g2.POST("/nodes/config/import", v1.NodeConfigImport())
 
 	g2.GET("/stats", v1.StatsIndex(s.database))
 	g2.PATCH("/stats", v1.StatsUpdatePartial(s.database))
+	g2.GET("/insights", v1.InsightsIndex(s.database))
 
 	g2.GET("/information", v1.InformationIndex(s.licensor))
 
@@ -91,6 +103,11 @@ func (s *Server) Run() {
 
 	g2.POST("/imports", v1.ImportsStore(s.database, s.hc))
 
+	g2.GET("/backups", v1.BackupsIndex(s.database))
+	g2.POST("/backups", v1.BackupsStore(s.database))
+	g2.GET("/backups/:name", v1.BackupsShow(s.database))
+	g2.POST("/backups/:name/restore", v1.BackupsRestore(s.coordinator, s.database))
+
 	// Protocol management endpoints
 	g2.GET("/protocols", v1.ProtocolsList(s.database))
 	g2.POST("/generate-reality-keys", v1.GenerateRealityKeys(s.database))
@@ -0,0 +1,551 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/ebadidev/arch-manager/internal/writer"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/infra/conf/serial"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Report is the result of an import, the node of the inbounds and the settings that the node cannot hold.
+type Report struct {
+	Node        *database.Node `json:"node"`
+	Unsupported []string       `json:"unsupported"`
+}
+
+// unsupported reports the setting of the given inbound (or of the whole configuration for an empty inbound name).
+func (r *Report) unsupported(inbound, setting, reason string) {
+	if inbound == "" {
+		r.Unsupported = append(r.Unsupported, fmt.Sprintf("%s: %s", setting, reason))
+	} else {
+		r.Unsupported = append(r.Unsupported, fmt.Sprintf("inbound %q: %s: %s", inbound, setting, reason))
+	}
+}
+
+// unsupportedValues reports the settings of the given inbound that are set (not zero values).
+func (r *Report) unsupportedValues(inbound string, values map[string]interface{}) {
+	var settings []string
+	for setting, value := range values {
+		if v := reflect.ValueOf(value); v.IsValid() && !v.IsZero() {
+			settings = append(settings, setting)
+		}
+	}
+	// Maps have no order, but the report is read by people.
+	slices.Sort(settings)
+	for _, setting := range settings {
+		r.unsupported(inbound, setting, "not supported")
+	}
+}
+
+// XrayConfig maps the inbounds of the given Xray server configuration (config.json) to the inbounds of a node
+// configuration, tagged with the inbound tags. The configuration is decoded and each inbound is validated by the Xray
+// loader. The node is not complete, the node host, HTTP API, server name and server address are left for the admin,
+// as the Xray configuration does not have them.
+func XrayConfig(content []byte) (*Report, error) {
+	config, err := serial.DecodeJSONConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// The inbounds are validated on a copy, as building them sets the defaults of their settings.
+	validated, err := serial.DecodeJSONConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(config.InboundConfigs) == 0 {
+		return nil, errors.New("the configuration has no inbounds")
+	}
+
+	r := &Report{
+		Node:        &database.Node{CoreType: "xray", ServerIP: "0.0.0.0", Inbounds: []*database.Inbound{}},
+		Unsupported: []string{},
+	}
+
+	sections := []struct {
+		name string
+		set  bool
+	}{
+		{"dns", config.DNSConfig != nil},
+		{"routing", config.RouterConfig != nil && len(config.RouterConfig.RuleList) > 0},
+		{"reverse", config.Reverse != nil},
+		{"fakeDns", config.FakeDNS != nil},
+		{"observatory", config.Observatory != nil},
+		{"burstObservatory", config.BurstObservatory != nil},
+	}
+	for _, s := range sections {
+		if s.set {
+			r.unsupported("", s.name, "not imported, the node configurations are generated by arch-manager")
+		}
+	}
+	for _, o := range config.OutboundConfigs {
+		if o.Protocol != "freedom" && o.Protocol != "blackhole" {
+			r.unsupported("", fmt.Sprintf("outbound %q (%s)", o.Tag, o.Protocol), "not imported")
+		}
+	}
+
+	for i := range config.InboundConfigs {
+		in := &config.InboundConfigs[i]
+		name := in.Tag
+		if name == "" {
+			name = fmt.Sprintf("inbound-%d", i)
+		}
+
+		if in.Tag == "api" || in.Protocol == "dokodemo-door" || in.Protocol == "tunnel" {
+			// The API inbound of the Xray configuration, which arch-node makes itself.
+			continue
+		}
+		switch in.Protocol {
+		case "shadowsocks", "vmess", "vless", "trojan":
+		default:
+			r.unsupported(name, "protocol "+in.Protocol, "not supported, the inbound is skipped")
+			continue
+		}
+
+		if err = validate(&validated.InboundConfigs[i]); err != nil {
+			return nil, errors.Wrapf(err, "inbound %q", name)
+		}
+		inbound, err := r.inbound(name, in)
+		if err != nil {
+			return nil, errors.Wrapf(err, "inbound %q", name)
+		}
+		inbound.Tag = name
+		r.Node.Inbounds = append(r.Node.Inbounds, inbound)
+	}
+
+	return r, nil
+}
+
+// validate builds the inbound with the Xray loader. The certificates are left out, as the certificate files of the
+// server are not on this machine.
+func validate(in *conf.InboundDetourConfig) error {
+	if in.StreamSetting != nil && in.StreamSetting.TLSSettings != nil {
+		in.StreamSetting.TLSSettings.Certs = nil
+	}
+	_, err := in.Build()
+	return errors.WithStack(err)
+}
+
+// inbound maps the given (valid) inbound to a node inbound.
+func (r *Report) inbound(name string, in *conf.InboundDetourConfig) (*database.Inbound, error) {
+	inbound := &database.Inbound{
+		Protocol:    in.Protocol,
+		ListeningIP: "0.0.0.0",
+		Security:    "none",
+		CertMode:    "none",
+		NetworkSettings: database.NetworkConfig{
+			Transport: "tcp",
+			Settings:  map[string]interface{}{},
+		},
+	}
+
+	if in.PortList == nil || len(in.PortList.Range) == 0 {
+		return nil, errors.New("no port")
+	}
+	// The first range is the server port range of the inbound, which listens on its first port and hops to the others.
+	port := utils.PortRange{From: int(in.PortList.Range[0].From), To: int(in.PortList.Range[0].To)}
+	if port.To-port.From+1 > writer.MaxPortRange {
+		r.unsupported(name, "port range "+port.String(), fmt.Sprintf("more than %d ports, the node listens on %d", writer.MaxPortRange, port.From))
+		port.To = port.From
+	}
+	if len(in.PortList.Range) > 1 {
+		r.unsupported(name, "port list", fmt.Sprintf("not supported, the node listens on %s", port))
+	}
+	inbound.ListeningPort = port.From
+	inbound.ServerPort = port.String()
+
+	if in.ListenOn != nil {
+		if in.ListenOn.Family().IsIP() {
+			inbound.ListeningIP = in.ListenOn.IP().String()
+		} else {
+			r.unsupported(name, "listen "+in.ListenOn.String(), "not supported, the node listens on 0.0.0.0")
+		}
+	}
+
+	if in.SniffingConfig != nil && in.SniffingConfig.Enabled {
+		r.unsupported(name, "sniffing", "not supported")
+	}
+	if in.Allocation != nil {
+		r.unsupported(name, "allocate", "not supported")
+	}
+
+	if err := r.settings(name, in, inbound); err != nil {
+		return nil, err
+	}
+	if in.StreamSetting != nil {
+		if err := r.stream(name, in.StreamSetting, inbound); err != nil {
+			return nil, err
+		}
+	}
+
+	return inbound, nil
+}
+
+// settings maps the protocol settings of the given inbound. The clients are not imported, as arch-manager makes the
+// clients from its users.
+func (r *Report) settings(name string, in *conf.InboundDetourConfig, inbound *database.Inbound) error {
+	var raw []byte
+	if in.Settings != nil {
+		raw = *in.Settings
+	} else {
+		raw = []byte("{}")
+	}
+
+	clients := func(count int) {
+		if count > 0 {
+			r.unsupported(name, fmt.Sprintf("settings.clients (%d)", count), "not imported, the clients are the users")
+		}
+	}
+
+	switch in.Protocol {
+	case "shadowsocks":
+		var s conf.ShadowsocksServerConfig
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return errors.WithStack(err)
+		}
+		inbound.Encryption = s.Cipher
+		clients(len(s.Users))
+		if s.Password != "" {
+			r.unsupported(name, "settings.password", "not imported, arch-manager generates the keys")
+		}
+		r.unsupportedValues(name, map[string]interface{}{
+			"settings.network": s.NetworkList,
+			"settings.ivCheck": s.IVCheck,
+		})
+	case "vmess":
+		var s conf.VMessInboundConfig
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return errors.WithStack(err)
+		}
+		inbound.Encryption = "auto"
+		clients(len(s.Users))
+		r.unsupportedValues(name, map[string]interface{}{
+			"settings.default": s.Defaults,
+			"settings.detour":  s.DetourConfig,
+		})
+	case "vless":
+		var s conf.VLessInboundConfig
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return errors.WithStack(err)
+		}
+		inbound.Encryption = "none"
+		clients(len(s.Clients))
+		for _, c := range s.Clients {
+			var client struct {
+				Flow string `json:"flow"`
+			}
+			if json.Unmarshal(c, &client) == nil && client.Flow != "" {
+				r.unsupported(name, "settings.clients.flow "+client.Flow, "not supported")
+				break
+			}
+		}
+		if s.Decryption != "" && s.Decryption != "none" {
+			r.unsupported(name, "settings.decryption", "not supported, the node uses none")
+		}
+		r.unsupportedValues(name, map[string]interface{}{
+			"settings.fallbacks": s.Fallbacks,
+		})
+	case "trojan":
+		var s conf.TrojanServerConfig
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return errors.WithStack(err)
+		}
+		inbound.Encryption = "none"
+		clients(len(s.Clients))
+		r.unsupportedValues(name, map[string]interface{}{
+			"settings.fallbacks": s.Fallbacks,
+		})
+	}
+
+	return nil
+}
+
+// stream maps the stream settings of an inbound to the network and security settings of the node inbound, with the
+// settings keys that the writer reads.
+func (r *Report) stream(name string, stream *conf.StreamConfig, inbound *database.Inbound) error {
+	network := "tcp"
+	if stream.Network != nil {
+		network = strings.ToLower(string(*stream.Network))
+	}
+
+	s := inbound.NetworkSettings.Settings
+	switch network {
+	case "tcp", "raw":
+		tcp := stream.RAWSettings
+		if tcp == nil {
+			tcp = stream.TCPSettings
+		}
+		if tcp == nil {
+			break
+		}
+		inbound.NetworkSettings.AcceptProxyProtocol = tcp.AcceptProxyProtocol
+		if len(tcp.HeaderConfig) == 0 {
+			break
+		}
+		var header map[string]interface{}
+		if err := json.Unmarshal(tcp.HeaderConfig, &header); err != nil {
+			return errors.WithStack(err)
+		}
+		if header["type"] == "http" {
+			// The http transport of the nodes is TCP with the HTTP header.
+			inbound.NetworkSettings.Transport = "http"
+			if request, ok := header["request"].(map[string]interface{}); ok {
+				if path := firstString(request["path"]); path != "" {
+					s["path"] = path
+				}
+				if headers, ok := request["headers"].(map[string]interface{}); ok {
+					if host := firstString(headers["Host"]); host != "" {
+						s["host"] = host
+					}
+				}
+			}
+			if response, ok := header["response"].(map[string]interface{}); ok {
+				if headers, ok := response["headers"].(map[string]interface{}); ok {
+					for key, value := range headers {
+						if v, ok := value.(string); ok {
+							headers[key] = []interface{}{v}
+						}
+					}
+					s["headers"] = headers
+				}
+			}
+		}
+	case "ws", "websocket":
+		inbound.NetworkSettings.Transport = "ws"
+		if ws := stream.WSSettings; ws != nil {
+			inbound.NetworkSettings.AcceptProxyProtocol = ws.AcceptProxyProtocol
+			setString(s, "path", ws.Path)
+			setString(s, "host", ws.Host)
+			var headers []string
+			for key, value := range ws.Headers {
+				if key == "Host" && ws.Host == "" {
+					setString(s, "host", value)
+				} else {
+					headers = append(headers, key)
+				}
+			}
+			r.unsupportedValues(name, map[string]interface{}{
+				"wsSettings.headers":         headers,
+				"wsSettings.heartbeatPeriod": ws.HeartbeatPeriod,
+			})
+		}
+	case "grpc":
+		inbound.NetworkSettings.Transport = "grpc"
+		if grpc := stream.GRPCSettings; grpc != nil {
+			setString(s, "serviceName", grpc.ServiceName)
+			setString(s, "authority", grpc.Authority)
+			r.unsupportedValues(name, map[string]interface{}{
+				"grpcSettings.multiMode":             grpc.MultiMode,
+				"grpcSettings.idle_timeout":          grpc.IdleTimeout,
+				"grpcSettings.health_check_timeout":  grpc.HealthCheckTimeout,
+				"grpcSettings.permit_without_stream": grpc.PermitWithoutStream,
+				"grpcSettings.initial_windows_size":  grpc.InitialWindowsSize,
+				"grpcSettings.user_agent":            grpc.UserAgent,
+			})
+		}
+	case "kcp", "mkcp":
+		inbound.NetworkSettings.Transport = "kcp"
+		if kcp := stream.KCPSettings; kcp != nil {
+			if kcp.Mtu != nil {
+				s["mtu"] = float64(*kcp.Mtu)
+			}
+			if kcp.Seed != nil {
+				setString(s, "seed", *kcp.Seed)
+			}
+			if len(kcp.HeaderConfig) > 0 {
+				var header map[string]interface{}
+				if err := json.Unmarshal(kcp.HeaderConfig, &header); err != nil {
+					return errors.WithStack(err)
+				}
+				s["header"] = header
+			}
+			r.unsupportedValues(name, map[string]interface{}{
+				"kcpSettings.tti":              kcp.Tti,
+				"kcpSettings.uplinkCapacity":   kcp.UpCap,
+				"kcpSettings.downlinkCapacity": kcp.DownCap,
+				"kcpSettings.congestion":       kcp.Congestion,
+				"kcpSettings.readBufferSize":   kcp.ReadBufferSize,
+				"kcpSettings.writeBufferSize":  kcp.WriteBufferSize,
+			})
+		}
+	case "httpupgrade":
+		inbound.NetworkSettings.Transport = "httpupgrade"
+		if hu := stream.HTTPUPGRADESettings; hu != nil {
+			inbound.NetworkSettings.AcceptProxyProtocol = hu.AcceptProxyProtocol
+			setString(s, "path", hu.Path)
+			setString(s, "host", hu.Host)
+			r.unsupportedValues(name, map[string]interface{}{
+				"httpupgradeSettings.headers": hu.Headers,
+			})
+		}
+	case "xhttp", "splithttp":
+		inbound.NetworkSettings.Transport = "xhttp"
+		xhttp := stream.XHTTPSettings
+		if xhttp == nil {
+			xhttp = stream.SplitHTTPSettings
+		}
+		if xhttp != nil {
+			setString(s, "path", xhttp.Path)
+			setString(s, "host", xhttp.Host)
+			setString(s, "mode", xhttp.Mode)
+			if xhttp.NoGRPCHeader {
+				s["noGRPCHeader"] = true
+			}
+			if xhttp.NoSSEHeader {
+				s["noSSEHeader"] = true
+			}
+			r.unsupportedValues(name, map[string]interface{}{
+				"xhttpSettings.headers":              xhttp.Headers,
+				"xhttpSettings.xPaddingBytes":        xhttp.XPaddingBytes,
+				"xhttpSettings.scMaxEachPostBytes":   xhttp.ScMaxEachPostBytes,
+				"xhttpSettings.scMinPostsIntervalMs": xhttp.ScMinPostsIntervalMs,
+				"xhttpSettings.scMaxBufferedPosts":   xhttp.ScMaxBufferedPosts,
+				"xhttpSettings.scStreamUpServerSecs": xhttp.ScStreamUpServerSecs,
+				"xhttpSettings.xmux":                 xhttp.Xmux,
+				"xhttpSettings.downloadSettings":     xhttp.DownloadSettings,
+				"xhttpSettings.extra":                xhttp.Extra,
+			})
+		}
+	default:
+		return errors.Errorf("unsupported transport: %s", network)
+	}
+
+	if sockopt := stream.SocketSettings; sockopt != nil {
+		inbound.NetworkSettings.AcceptProxyProtocol = inbound.NetworkSettings.AcceptProxyProtocol || sockopt.AcceptProxyProtocol
+		rest := *sockopt
+		rest.AcceptProxyProtocol = false
+		r.unsupportedValues(name, map[string]interface{}{"sockopt": rest})
+	}
+
+	switch strings.ToLower(stream.Security) {
+	case "", "none":
+	case "tls":
+		inbound.Security = "tls"
+		inbound.CertMode = ""
+		r.unsupported(name, "security tls", "not supported, the nodes cannot receive certificates, pick reality or none")
+		tls := stream.TLSSettings
+		if tls == nil {
+			tls = &conf.TLSConfig{}
+		}
+		inbound.SecuritySettings.TLS = &database.TLSConfig{
+			ServerName:         tls.ServerName,
+			RejectUnknownSni:   tls.RejectUnknownSNI,
+			AllowInsecure:      tls.Insecure,
+			Fingerprint:        tls.Fingerprint,
+			ServerNameToVerify: tls.ServerNameToVerify,
+		}
+		if tls.ALPN != nil {
+			inbound.SecuritySettings.TLS.ALPN = *tls.ALPN
+		}
+		if tls.CurvePreferences != nil {
+			inbound.SecuritySettings.TLS.CurvePreferences = strings.Join(*tls.CurvePreferences, ",")
+		}
+		if len(tls.Certs) > 0 {
+			r.unsupported(name, "tlsSettings.certificates", "not imported")
+		}
+		r.unsupportedValues(name, map[string]interface{}{
+			"tlsSettings.enableSessionResumption":              tls.EnableSessionResumption,
+			"tlsSettings.disableSystemRoot":                    tls.DisableSystemRoot,
+			"tlsSettings.minVersion":                           tls.MinVersion,
+			"tlsSettings.maxVersion":                           tls.MaxVersion,
+			"tlsSettings.cipherSuites":                         tls.CipherSuites,
+			"tlsSettings.pinnedPeerCertificateChainSha256":     tls.PinnedPeerCertificateChainSha256,
+			"tlsSettings.pinnedPeerCertificatePublicKeySha256": tls.PinnedPeerCertificatePublicKeySha256,
+			"tlsSettings.masterKeyLog":                         tls.MasterKeyLog,
+			"tlsSettings.verifyPeerCertInNames":                tls.VerifyPeerCertInNames,
+			"tlsSettings.echServerKeys":                        tls.ECHServerKeys,
+			"tlsSettings.echConfigList":                        tls.ECHConfigList,
+			"tlsSettings.echForceQuery":                        tls.ECHForceQuery,
+			"tlsSettings.echSockopt":                           tls.ECHSocketSettings,
+		})
+	case "reality":
+		inbound.Security = "reality"
+		reality := stream.REALITYSettings
+		if reality == nil {
+			return errors.New("no realitySettings")
+		}
+		dest := reality.Target
+		if len(dest) == 0 {
+			dest = reality.Dest
+		}
+		inbound.SecuritySettings.Reality = &database.RealityConfig{
+			Show:          reality.Show,
+			Dest:          rawString(dest),
+			PrivateKey:    reality.PrivateKey,
+			PublicKey:     publicKey(reality.PrivateKey),
+			MinClientVer:  reality.MinClientVer,
+			MaxClientVer:  reality.MaxClientVer,
+			MaxTimeDiff:   int(reality.MaxTimeDiff),
+			ProxyProtocol: int(reality.Xver),
+			ShortIDs:      reality.ShortIds,
+			ServerNames:   reality.ServerNames,
+		}
+		r.unsupportedValues(name, map[string]interface{}{
+			"realitySettings.type":                  reality.Type,
+			"realitySettings.masterKeyLog":          reality.MasterKeyLog,
+			"realitySettings.mldsa65Seed":           reality.Mldsa65Seed,
+			"realitySettings.limitFallbackUpload":   reality.LimitFallbackUpload,
+			"realitySettings.limitFallbackDownload": reality.LimitFallbackDownload,
+		})
+	default:
+		return errors.Errorf("unsupported security: %s", stream.Security)
+	}
+
+	return nil
+}
+
+// publicKey returns the Reality public key of the given private key, or an empty string for an invalid key.
+func publicKey(privateKey string) string {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(privateKey, "="))
+	if err != nil || len(key) != curve25519.ScalarSize {
+		return ""
+	}
+	public, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(public)
+}
+
+// rawString returns the given JSON string or number as a string (Reality targets are "host:port" or a port).
+func rawString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		return n.String()
+	}
+	return ""
+}
+
+// firstString returns the given string, or the first string of the given list.
+func firstString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		if len(v) > 0 {
+			s, _ := v[0].(string)
+			return s
+		}
+	}
+	return ""
+}
+
+// setString sets the key of the settings to the given value, unless it is empty.
+func setString(settings map[string]interface{}, key, value string) {
+	if value != "" {
+		settings[key] = value
+	}
+}
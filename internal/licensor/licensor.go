@@ -37,7 +37,7 @@ func (l *Licensor) Run() {
 
 func (l *Licensor) fetch() {
 	body := map[string]interface{}{
-		"host": l.database.Content.Settings.Host,
+		"host": l.database.Settings().Host,
 		"port": l.c.HttpServer.Port,
 	}
 	if r, err := l.hc.Do(http.MethodPost, config.LicenseServer, config.LicenseToken, body); err != nil {
@@ -68,7 +68,7 @@ func (l *Licensor) validate() error {
 		return errors.WithStack(err)
 	}
 
-	key := fmt.Sprintf("%s:%d", l.database.Content.Settings.Host, l.c.HttpServer.Port)
+	key := fmt.Sprintf("%s:%d", l.database.Settings().Host, l.c.HttpServer.Port)
 	l.licensed = l.enigma.Verify(key, string(licenseFile))
 	l.l.Info("licensor: license file checked", zap.Bool("valid", l.licensed))
 
@@ -0,0 +1,346 @@
+package links
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/database"
+)
+
+// Parse returns the node configuration of the given client link (vless://, vmess://, trojan:// or ss://), with a single
+// inbound tagged with its protocol.
+// It is the inverse of the profile link generators: the protocol, server, network and security fields are set,
+// and the fields that links do not carry (the node host and HTTP API, the Reality private key, the certificate mode
+// of TLS) are left for the admin.
+// The TCP links with the HTTP header are parsed to the http transport, which the nodes serve as TCP with HTTP header
+// obfuscation, for every protocol.
+func Parse(link string) (*database.Node, error) {
+	scheme, _, found := strings.Cut(strings.TrimSpace(link), "://")
+	if !found {
+		return nil, errors.New("not a link")
+	}
+
+	var node *database.Node
+	var err error
+	switch strings.ToLower(scheme) {
+	case "vless", "trojan":
+		node, err = parseURL(strings.TrimSpace(link))
+	case "vmess":
+		node, err = parseVMess(strings.TrimSpace(link))
+	case "ss":
+		node, err = parseShadowsocks(strings.TrimSpace(link))
+	default:
+		return nil, errors.Errorf("unsupported link scheme: %s", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	node.CoreType = "xray"
+	node.ServerIP = "0.0.0.0"
+	if net.ParseIP(node.ServerAddr) != nil {
+		node.ServerIP = node.ServerAddr
+	}
+
+	in := node.Inbounds[0]
+	in.Tag = in.Protocol
+	in.ListeningIP = "0.0.0.0"
+	in.ServerPort = strconv.Itoa(in.ListeningPort)
+	if in.NetworkSettings.Transport == "" {
+		in.NetworkSettings.Transport = "tcp"
+	}
+	if in.Security == "" {
+		in.Security = "none"
+	}
+	if in.Security != "tls" {
+		in.CertMode = "none"
+	}
+	return node, nil
+}
+
+// parseURL parses the VLESS and Trojan links, which share the query parameters.
+func parseURL(link string) (*database.Node, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || port < 1 || port > 65535 {
+		return nil, errors.Errorf("invalid port: %s", u.Port())
+	}
+
+	q := u.Query()
+	in := &database.Inbound{
+		Protocol:      strings.ToLower(u.Scheme),
+		ListeningPort: port,
+		Encryption:    "none",
+		Security:      q.Get("security"),
+		NetworkSettings: database.NetworkConfig{
+			Transport: q.Get("type"),
+			Settings:  map[string]interface{}{},
+		},
+	}
+	if in.Protocol == "trojan" {
+		// Trojan links are always generated with TLS.
+		in.Security = "tls"
+	}
+
+	switch in.Security {
+	case "", "none":
+		in.Security = "none"
+	case "tls":
+		in.SecuritySettings.TLS = &database.TLSConfig{
+			ServerName:    q.Get("sni"),
+			SNI:           q.Get("sni"),
+			Fingerprint:   q.Get("fp"),
+			ALPN:          splitList(q.Get("alpn")),
+			AllowInsecure: q.Get("allowInsecure") == "1" || q.Get("allowInsecure") == "true",
+		}
+	case "reality":
+		reality := &database.RealityConfig{
+			Fingerprint: q.Get("fp"),
+			PublicKey:   q.Get("pbk"),
+			SpiderX:     q.Get("spx"),
+		}
+		if sni := q.Get("sni"); sni != "" {
+			reality.ServerNames = []string{sni}
+		}
+		if sid := q.Get("sid"); sid != "" {
+			reality.ShortIDs = []string{sid}
+		}
+		in.SecuritySettings.Reality = reality
+	default:
+		return nil, errors.Errorf("unsupported security: %s", in.Security)
+	}
+
+	s := in.NetworkSettings.Settings
+	set := func(key, param string) {
+		if q.Has(param) {
+			s[key] = q.Get(param)
+		}
+	}
+	switch in.NetworkSettings.Transport {
+	case "", "tcp":
+		if q.Get("headerType") == "http" || q.Has("path") || q.Has("host") {
+			in.NetworkSettings.Transport = "http"
+			set("path", "path")
+			set("host", "host")
+		}
+	case "ws", "http", "httpupgrade":
+		set("path", "path")
+		set("host", "host")
+	case "grpc":
+		set("serviceName", "serviceName")
+		set("authority", "authority")
+	case "xhttp":
+		set("path", "path")
+		set("host", "host")
+		set("mode", "mode")
+		set("custom_host", "custom_host")
+		if q.Get("noGRPCHeader") == "true" {
+			s["noGRPCHeader"] = true
+		}
+		if q.Get("noSSEHeader") == "true" {
+			s["noSSEHeader"] = true
+		}
+	case "kcp":
+		set("seed", "seed")
+		if q.Has("headerType") || q.Has("host") {
+			header := map[string]interface{}{}
+			if q.Has("headerType") {
+				header["type"] = q.Get("headerType")
+			}
+			if q.Has("host") {
+				header["domain"] = q.Get("host")
+			}
+			s["header"] = header
+		}
+	default:
+		return nil, errors.Errorf("unsupported transport: %s", in.NetworkSettings.Transport)
+	}
+
+	if q.Has("fragment") {
+		in.Fragment = true
+		in.FragmentValue = q.Get("fragment")
+	}
+
+	return &database.Node{ServerName: u.Fragment, ServerAddr: u.Hostname(), Inbounds: []*database.Inbound{in}}, nil
+}
+
+// parseVMess parses the VMess links, a base64-encoded JSON object in the v2rayN format.
+func parseVMess(link string) (*database.Node, error) {
+	content, err := decodeBase64(link[len("vmess://"):])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid vmess link")
+	}
+
+	var config map[string]interface{}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return nil, errors.Wrap(err, "invalid vmess link")
+	}
+	field := func(key string) string {
+		if value, ok := config[key]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+		return ""
+	}
+
+	port, err := strconv.Atoi(field("port"))
+	if err != nil || port < 1 || port > 65535 {
+		return nil, errors.Errorf("invalid port: %s", field("port"))
+	}
+
+	in := &database.Inbound{
+		Protocol:      "vmess",
+		ListeningPort: port,
+		Encryption:    field("scy"),
+		NetworkSettings: database.NetworkConfig{
+			Transport: field("net"),
+			Settings:  map[string]interface{}{},
+		},
+	}
+	if in.Encryption == "" {
+		in.Encryption = "auto"
+	}
+
+	switch field("tls") {
+	case "", "none":
+		in.Security = "none"
+	case "tls":
+		in.Security = "tls"
+		in.SecuritySettings.TLS = &database.TLSConfig{
+			ServerName:  field("sni"),
+			SNI:         field("sni"),
+			Fingerprint: field("fp"),
+			ALPN:        splitList(field("alpn")),
+		}
+	default:
+		return nil, errors.Errorf("unsupported security: %s", field("tls"))
+	}
+
+	s := in.NetworkSettings.Settings
+	set := func(key, value string) {
+		if value != "" {
+			s[key] = value
+		}
+	}
+	switch in.NetworkSettings.Transport {
+	case "", "tcp":
+		if field("type") == "http" {
+			in.NetworkSettings.Transport = "http"
+			set("path", field("path"))
+			set("host", field("host"))
+		}
+	case "ws", "http", "httpupgrade", "xhttp":
+		set("path", field("path"))
+		set("host", field("host"))
+	case "grpc":
+		set("serviceName", field("path"))
+		set("authority", field("host"))
+	case "kcp":
+		set("seed", field("path"))
+		header := map[string]interface{}{}
+		if t := field("type"); t != "" && t != "none" {
+			header["type"] = t
+		}
+		if domain := field("host"); domain != "" {
+			header["domain"] = domain
+		}
+		if len(header) > 0 {
+			s["header"] = header
+		}
+	default:
+		return nil, errors.Errorf("unsupported transport: %s", in.NetworkSettings.Transport)
+	}
+
+	return &database.Node{ServerName: field("ps"), ServerAddr: field("add"), Inbounds: []*database.Inbound{in}}, nil
+}
+
+// parseShadowsocks parses the Shadowsocks links, ss://base64(method:password)@host:port#name (SIP002),
+// or the legacy ss://base64(method:password@host:port)#name.
+// The standard base64 of the generators may hold "/", so the link is split by hand rather than parsed as a URL.
+func parseShadowsocks(link string) (*database.Node, error) {
+	rest := link[len("ss://"):]
+	rest, fragment, _ := strings.Cut(rest, "#")
+	name, err := url.PathUnescape(fragment)
+	if err != nil {
+		name = fragment
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+
+	userInfo, hostPort, found := cutLast(rest, "@")
+	if !found {
+		decoded, err := decodeBase64(rest)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid shadowsocks link")
+		}
+		if userInfo, hostPort, found = cutLast(string(decoded), "@"); !found {
+			return nil, errors.New("invalid shadowsocks link: no server")
+		}
+	} else if decoded, err := decodeBase64(userInfo); err == nil {
+		userInfo = string(decoded)
+	} else if unescaped, err := url.PathUnescape(userInfo); err == nil {
+		userInfo = unescaped
+	}
+
+	method, _, found := strings.Cut(userInfo, ":")
+	if !found || method == "" {
+		return nil, errors.New("invalid shadowsocks link: no method")
+	}
+
+	host, portValue, err := net.SplitHostPort(strings.TrimSuffix(hostPort, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid shadowsocks link")
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, errors.Errorf("invalid port: %s", portValue)
+	}
+
+	return &database.Node{
+		ServerName: name,
+		ServerAddr: host,
+		Inbounds: []*database.Inbound{{
+			Protocol:        "shadowsocks",
+			ListeningPort:   port,
+			Encryption:      method,
+			Security:        "none",
+			NetworkSettings: database.NetworkConfig{Transport: "tcp"},
+		}},
+	}, nil
+}
+
+// decodeBase64 decodes standard or URL-safe base64, with or without padding.
+func decodeBase64(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return decoded, nil
+	}
+	if decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "=")); err == nil {
+		return decoded, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	return decoded, errors.WithStack(err)
+}
+
+// cutLast slices the value around the last instance of the separator.
+func cutLast(value, separator string) (before, after string, found bool) {
+	if i := strings.LastIndex(value, separator); i >= 0 {
+		return value[:i], value[i+len(separator):], true
+	}
+	return value, "", false
+}
+
+// splitList splits a comma-separated list, or returns nil for an empty one.
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
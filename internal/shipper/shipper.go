@@ -0,0 +1,171 @@
+package shipper
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/ebadidev/arch-node/pkg/logger"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.uber.org/zap"
+)
+
+// ObjectSuffix is appended to the backup names of the uploaded objects, which are encrypted.
+const ObjectSuffix = ".enc"
+
+// client holds the calls of the MinIO client that the shipper makes once the bucket exists.
+type client interface {
+	PutObject(ctx context.Context, bucket, name string, reader io.Reader, size int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	ListObjects(ctx context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	RemoveObject(ctx context.Context, bucket, name string, opts minio.RemoveObjectOptions) error
+}
+
+// Shipper uploads the database backups to an S3-compatible bucket.
+// Backups are encrypted before they leave the server, and the bucket follows the local retention.
+type Shipper struct {
+	c      *config.Config
+	l      *logger.Logger
+	d      *database.Database
+	client client
+}
+
+func (s *Shipper) Enabled() bool {
+	return s.c.Database.Backup.Remote.Enabled
+}
+
+// init creates the client and the bucket on the first upload.
+func (s *Shipper) init(ctx context.Context) error {
+	if s.client != nil {
+		return nil
+	}
+
+	r := s.c.Database.Backup.Remote
+	client, err := minio.New(r.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(r.AccessKey, r.SecretKey, ""),
+		Secure: r.Secure,
+		Region: r.Region,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	exists, err := client.BucketExists(ctx, r.Bucket)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !exists {
+		if err = client.MakeBucket(ctx, r.Bucket, minio.MakeBucketOptions{Region: r.Region}); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	s.client = client
+	return nil
+}
+
+// Ship uploads the given backup and removes the uploaded backups expired by the retention.
+func (s *Shipper) Ship(ctx context.Context, backup *database.Backup) error {
+	if !s.Enabled() {
+		return nil
+	}
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+
+	_, file, err := s.d.FindBackup(backup.Name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	key, err := Key(s.c)
+	if err != nil {
+		return err
+	}
+	content, err = utils.Encrypt(key, content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	r := s.c.Database.Backup.Remote
+	name := s.objectName(backup.Name)
+	_, err = s.client.PutObject(ctx, r.Bucket, name, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot upload %s", name)
+	}
+	s.l.Info("shipper: backup uploaded", zap.String("object", name), zap.Int("size", len(content)))
+
+	return s.prune(ctx)
+}
+
+// prune removes the uploaded backups beyond the retention of their tier.
+func (s *Shipper) prune(ctx context.Context) error {
+	r := s.c.Database.Backup.Remote
+
+	var backups []*database.Backup
+	for object := range s.client.ListObjects(ctx, r.Bucket, minio.ListObjectsOptions{Prefix: s.objectName("")}) {
+		if object.Err != nil {
+			return errors.WithStack(object.Err)
+		}
+		name := strings.TrimSuffix(path.Base(object.Key), ObjectSuffix)
+		if backup, ok := database.ParseBackupName(name); ok {
+			backup.Size = object.Size
+			backups = append(backups, backup)
+		}
+	}
+	slices.SortStableFunc(backups, func(a, b *database.Backup) int {
+		return int(a.CreatedAt - b.CreatedAt)
+	})
+
+	for _, backup := range database.ExpiredBackups(backups, s.d.BackupRetention()) {
+		name := s.objectName(backup.Name)
+		if err := s.client.RemoveObject(ctx, r.Bucket, name, minio.RemoveObjectOptions{}); err != nil {
+			return errors.Wrapf(err, "cannot remove %s", name)
+		}
+		s.l.Info("shipper: expired backup removed", zap.String("object", name))
+	}
+
+	return nil
+}
+
+func (s *Shipper) objectName(backupName string) string {
+	prefix := strings.Trim(s.c.Database.Backup.Remote.Prefix, "/")
+	if backupName == "" {
+		if prefix == "" {
+			return ""
+		}
+		return prefix + "/"
+	}
+	return path.Join(prefix, backupName+ObjectSuffix)
+}
+
+// Key returns the configured key that encrypts the uploaded backups.
+func Key(c *config.Config) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(c.Database.Backup.Remote.EncryptionKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid backup encryption key")
+	}
+	if len(key) != 32 {
+		return nil, errors.New("backup encryption key must be 32 bytes")
+	}
+	return key, nil
+}
+
+func New(c *config.Config, l *logger.Logger, d *database.Database) *Shipper {
+	return &Shipper{c: c, l: l, d: d}
+}
@@ -0,0 +1,186 @@
+package shipper
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/ebadidev/arch-node/pkg/logger"
+	"github.com/minio/minio-go/v7"
+)
+
+// fakeClient is a bucket in memory, by object name.
+type fakeClient struct {
+	objects map[string][]byte
+}
+
+func (f *fakeClient) PutObject(_ context.Context, _, name string, reader io.Reader, _ int64, _ minio.PutObjectOptions) (minio.UploadInfo, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	f.objects[name] = content
+	return minio.UploadInfo{Key: name, Size: int64(len(content))}, nil
+}
+
+func (f *fakeClient) ListObjects(_ context.Context, _ string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo, len(f.objects))
+	for name, content := range f.objects {
+		if strings.HasPrefix(name, opts.Prefix) {
+			objects <- minio.ObjectInfo{Key: name, Size: int64(len(content))}
+		}
+	}
+	close(objects)
+	return objects
+}
+
+func (f *fakeClient) RemoveObject(_ context.Context, _, name string, _ minio.RemoveObjectOptions) error {
+	delete(f.objects, name)
+	return nil
+}
+
+func newTestShipper(t *testing.T, prefix string) (*Shipper, *fakeClient) {
+	t.Helper()
+
+	// The logger and the database write to the storage of the working directory
+	directory := t.TempDir()
+	t.Chdir(directory)
+	t.Setenv(database.MasterKeyEnv, "")
+	for _, path := range []string{"storage/logs", "storage/database", "storage/app"} {
+		if err := os.MkdirAll(filepath.Join(directory, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := logger.New("error", "2006-01-02 15:04:05", nil)
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &config.Config{Env: config.NewEnv(directory)}
+	c.Database.Driver = "json"
+	c.Database.Backup.Manual = 2
+	c.Database.Backup.Remote.Enabled = true
+	c.Database.Backup.Remote.Bucket = "backups"
+	c.Database.Backup.Remote.Prefix = prefix
+	c.Database.Backup.Remote.EncryptionKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+	d := database.New(l, c)
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeClient{objects: map[string][]byte{}}
+	s := New(c, l, d)
+	s.client = fake
+	return s, fake
+}
+
+func TestShip(t *testing.T) {
+	s, fake := newTestShipper(t, "/arch/")
+	for _, name := range []string{
+		"arch/backup-manual-20240101-000000.json.enc",
+		"arch/backup-manual-20240102-000000.json.enc",
+		"arch/notes.txt",
+		"other/backup-manual-20240101-000000.json.enc",
+	} {
+		fake.objects[name] = []byte("old")
+	}
+
+	backup, err := s.d.CreateBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Ship(context.Background(), backup); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "arch/" + backup.Name + ObjectSuffix
+	uploaded, found := fake.objects[name]
+	if !found {
+		t.Fatalf("got the objects %v, want %s", fake.objects, name)
+	}
+
+	// The object is the encrypted backup file
+	_, file, err := s.d.FindBackup(backup.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(uploaded, content) {
+		t.Error("got the backup uploaded in plaintext")
+	}
+	key, err := Key(s.c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := utils.Decrypt(key, uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, content) {
+		t.Error("got a decrypted object that is not the backup file")
+	}
+
+	// The manual retention keeps two backups under the prefix, and the other objects are left alone
+	if _, found = fake.objects["arch/backup-manual-20240101-000000.json.enc"]; found {
+		t.Error("got the expired backup in the bucket")
+	}
+	for _, kept := range []string{
+		"arch/backup-manual-20240102-000000.json.enc",
+		"arch/notes.txt",
+		"other/backup-manual-20240101-000000.json.enc",
+	} {
+		if _, found = fake.objects[kept]; !found {
+			t.Errorf("got %s removed", kept)
+		}
+	}
+}
+
+func TestShipDisabled(t *testing.T) {
+	s, fake := newTestShipper(t, "")
+	s.c.Database.Backup.Remote.Enabled = false
+
+	backup, err := s.d.CreateBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Ship(context.Background(), backup); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.objects) > 0 {
+		t.Errorf("got the objects %v, want none", fake.objects)
+	}
+}
+
+func TestObjectName(t *testing.T) {
+	tests := []struct {
+		prefix string
+		backup string
+		want   string
+	}{
+		{prefix: "", backup: "backup-manual-20240101-000000.json", want: "backup-manual-20240101-000000.json.enc"},
+		{prefix: "", backup: "", want: ""},
+		{prefix: "arch", backup: "backup-manual-20240101-000000.json", want: "arch/backup-manual-20240101-000000.json.enc"},
+		{prefix: "/arch/", backup: "", want: "arch/"},
+		{prefix: "/servers/arch/", backup: "backup-daily-20240101-000000.json.gz", want: "servers/arch/backup-daily-20240101-000000.json.gz.enc"},
+	}
+
+	for _, tt := range tests {
+		s := &Shipper{c: &config.Config{}}
+		s.c.Database.Backup.Remote.Prefix = tt.prefix
+		if got := s.objectName(tt.backup); got != tt.want {
+			t.Errorf("%q and %q: got %q, want %q", tt.prefix, tt.backup, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package subscription
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the proxy groups of the Clash profile, which the rules of the template route to.
+const (
+	ClashGroupSelect = "Proxy"
+	ClashGroupAuto   = "Auto"
+)
+
+type clashProxy struct {
+	Name           string            `yaml:"name"`
+	Type           string            `yaml:"type"`
+	Server         string            `yaml:"server"`
+	Port           int               `yaml:"port"`
+	UDP            bool              `yaml:"udp"`
+	UUID           string            `yaml:"uuid,omitempty"`
+	AlterId        *int              `yaml:"alterId,omitempty"`
+	Password       string            `yaml:"password,omitempty"`
+	Cipher         string            `yaml:"cipher,omitempty"`
+	TLS            bool              `yaml:"tls,omitempty"`
+	ServerName     string            `yaml:"servername,omitempty"`
+	SNI            string            `yaml:"sni,omitempty"`
+	Fingerprint    string            `yaml:"client-fingerprint,omitempty"`
+	ALPN           []string          `yaml:"alpn,omitempty"`
+	SkipCertVerify bool              `yaml:"skip-cert-verify,omitempty"`
+	RealityOpts    *clashRealityOpts `yaml:"reality-opts,omitempty"`
+	Network        string            `yaml:"network,omitempty"`
+	WsOpts         *clashWsOpts      `yaml:"ws-opts,omitempty"`
+	GrpcOpts       *clashGrpcOpts    `yaml:"grpc-opts,omitempty"`
+	HttpOpts       *clashHttpOpts    `yaml:"http-opts,omitempty"`
+}
+
+type clashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortId   string `yaml:"short-id,omitempty"`
+}
+
+type clashWsOpts struct {
+	Path             string            `yaml:"path,omitempty"`
+	Headers          map[string]string `yaml:"headers,omitempty"`
+	V2rayHttpUpgrade bool              `yaml:"v2ray-http-upgrade,omitempty"`
+}
+
+type clashGrpcOpts struct {
+	ServiceName string `yaml:"grpc-service-name,omitempty"`
+}
+
+type clashHttpOpts struct {
+	Path    []string            `yaml:"path,omitempty"`
+	Headers map[string][]string `yaml:"headers,omitempty"`
+}
+
+type clashProxyGroup struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Proxies  []string `yaml:"proxies"`
+	URL      string   `yaml:"url,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+// Clash renders the given proxies as a Clash Meta (Mihomo) profile.
+// The profile is the given template, usually holding the general settings and the rules, with its `proxies` set to the
+// proxies, and its `proxy-groups` prefixed with a select group (ClashGroupSelect) and a url-test group (ClashGroupAuto).
+// Proxies with a transport Clash does not support (KCP, XHTTP) are left out.
+func Clash(proxies []*Proxy, template []byte) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(template, &document); err != nil {
+		return nil, errors.Wrap(err, "invalid clash template")
+	}
+	if len(document.Content) == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("invalid clash template: not a mapping")
+	}
+
+	var items []*clashProxy
+	var names []string
+	for _, p := range proxies {
+		if item := newClashProxy(p); item != nil {
+			items = append(items, item)
+			names = append(names, item.Name)
+		}
+	}
+
+	groups := []interface{}{
+		&clashProxyGroup{
+			Name:    ClashGroupSelect,
+			Type:    "select",
+			Proxies: append([]string{ClashGroupAuto}, append(names, "DIRECT")...),
+		},
+		&clashProxyGroup{
+			Name:     ClashGroupAuto,
+			Type:     "url-test",
+			Proxies:  append([]string{}, names...),
+			URL:      "https://www.gstatic.com/generate_204",
+			Interval: 300,
+		},
+	}
+	if existing := mappingValue(root, "proxy-groups"); existing != nil {
+		var templateGroups []interface{}
+		if err := existing.Decode(&templateGroups); err != nil {
+			return nil, errors.Wrap(err, "invalid clash template: proxy-groups")
+		}
+		groups = append(groups, templateGroups...)
+	}
+	if len(names) == 0 {
+		// Clash refuses empty groups.
+		groups[1].(*clashProxyGroup).Proxies = []string{"DIRECT"}
+	}
+
+	if err := setMappingValue(root, "proxies", items); err != nil {
+		return nil, err
+	}
+	if err := setMappingValue(root, "proxy-groups", groups); err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buffer.Bytes(), errors.WithStack(encoder.Close())
+}
+
+func newClashProxy(p *Proxy) *clashProxy {
+	c := &clashProxy{
+		Name:   p.Name,
+		Type:   p.Protocol,
+		Server: p.Server,
+		Port:   p.Port,
+		UDP:    true,
+	}
+
+	switch p.Protocol {
+	case "shadowsocks":
+		c.Type = "ss"
+		c.Cipher = p.Cipher
+		c.Password = p.Password
+		// Shadowsocks has no transport or TLS in Clash.
+		return c
+	case "vmess":
+		alterId := 0
+		c.UUID, c.AlterId, c.Cipher = p.UUID, &alterId, p.Cipher
+		if c.Cipher == "" {
+			c.Cipher = "auto"
+		}
+	case "vless":
+		c.UUID = p.UUID
+	case "trojan":
+		c.Password = p.Password
+	}
+
+	if p.TLS() {
+		c.Fingerprint = p.Fingerprint
+		c.ALPN = p.ALPN
+		c.SkipCertVerify = p.AllowInsecure
+		if p.Protocol == "trojan" {
+			c.SNI = p.SNI
+		} else {
+			c.TLS = true
+			c.ServerName = p.SNI
+		}
+		if p.Security == "reality" {
+			c.RealityOpts = &clashRealityOpts{PublicKey: p.PublicKey, ShortId: p.ShortID}
+		}
+	}
+
+	var headers map[string]string
+	if p.Host != "" {
+		headers = map[string]string{"Host": p.Host}
+	}
+	switch p.Transport {
+	case "tcp", "http":
+		// The nodes serve the http transport as TCP with the HTTP header obfuscation, not as HTTP/2.
+		if p.Transport == "http" || p.HeaderType == "http" {
+			c.Network = "http"
+			c.HttpOpts = &clashHttpOpts{Path: []string{p.Path}}
+			if p.Host != "" {
+				c.HttpOpts.Headers = map[string][]string{"Host": {p.Host}}
+			}
+		}
+	case "ws":
+		c.Network = "ws"
+		c.WsOpts = &clashWsOpts{Path: p.Path, Headers: headers}
+	case "httpupgrade":
+		c.Network = "ws"
+		c.WsOpts = &clashWsOpts{Path: p.Path, Headers: headers, V2rayHttpUpgrade: true}
+	case "grpc":
+		c.Network = "grpc"
+		c.GrpcOpts = &clashGrpcOpts{ServiceName: p.ServiceName}
+	default:
+		return nil
+	}
+
+	return c
+}
+
+// mappingValue returns the value of the given key of the YAML mapping, or nil if there is none.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets the value of the given key of the YAML mapping, in place or at the end.
+func setMappingValue(mapping *yaml.Node, key string, value interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return errors.WithStack(err)
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = &node
+			return nil
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, &node)
+	return nil
+}
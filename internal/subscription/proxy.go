@@ -0,0 +1,177 @@
+package subscription
+
+import (
+	"fmt"
+
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+)
+
+// Proxy is the client side of a node inbound for a user, read from the same node, user and settings fields as the
+// profile links. The subscription formats render it.
+type Proxy struct {
+	Node     *database.Node
+	Inbound  *database.Inbound
+	Name     string
+	Protocol string // shadowsocks, vmess, vless or trojan
+	Server   string
+	Port     int
+
+	UUID     string // VMess and VLESS
+	Password string // Shadowsocks and Trojan
+	Cipher   string // Shadowsocks method or VMess security
+
+	Transport   string // tcp, http, ws, grpc, kcp, httpupgrade or xhttp
+	Path        string
+	Host        string
+	ServiceName string
+	HeaderType  string // KCP header or TCP "http" header obfuscation
+	Seed        string
+	Mode        string // XHTTP mode
+
+	Security      string // none, tls or reality
+	SNI           string
+	Fingerprint   string
+	ALPN          []string
+	AllowInsecure bool
+	PublicKey     string
+	ShortID       string
+	SpiderX       string
+
+	Fragment *utils.Fragment // The fragment of the connections, or nil
+}
+
+// TLS reports whether the proxy connects with TLS or Reality.
+func (p *Proxy) TLS() bool {
+	return p.Security == "tls" || p.Security == "reality"
+}
+
+// Proxies returns the proxies of the given user for every inbound of the configured nodes, with unique names.
+// Inbounds with a server port range have a proxy on each of their hop ports as well, which the groups of the formats
+// pick from like from the other proxies, so the clients hop to another port when one is blocked.
+// Inbounds whose profile link is not generated (incomplete inbounds, Trojan without TLS) are skipped as well.
+func Proxies(d *database.Database, user *database.User) []*Proxy {
+	var proxies []*Proxy
+	names := map[string]bool{}
+	for _, node := range d.Nodes() {
+		for _, inbound := range node.Inbounds {
+			if inbound.Protocol == "" || inbound.ServerPort == "" {
+				continue
+			}
+			if inbound.Protocol == "trojan" && inbound.Security != "tls" {
+				continue
+			}
+
+			for _, in := range append([]*database.Inbound{inbound}, inbound.Hops()...) {
+				p := NewProxy(node, in, user, d.Settings())
+				if names[p.Name] {
+					p.Name = fmt.Sprintf("%s #%d", p.Name, node.Id)
+				}
+				names[p.Name] = true
+				proxies = append(proxies, p)
+			}
+		}
+	}
+	return proxies
+}
+
+// NewProxy returns the proxy of the given user for the given inbound of the node.
+func NewProxy(node *database.Node, inbound *database.Inbound, user *database.User, settings *database.Settings) *Proxy {
+	p := &Proxy{
+		Node:      node,
+		Inbound:   inbound,
+		Name:      node.InboundName(inbound),
+		Protocol:  inbound.Protocol,
+		Server:    settings.Host,
+		Port:      inbound.ListeningPort,
+		Transport: inbound.NetworkSettings.Transport,
+		Security:  inbound.Security,
+		Fragment:  utils.InboundFragment(inbound.Fragment, inbound.FragmentValue),
+	}
+	if p.Transport == "" {
+		p.Transport = "tcp"
+	}
+
+	switch inbound.Protocol {
+	case "vmess":
+		p.UUID, p.Cipher = user.UUID, inbound.Encryption
+	case "vless":
+		p.UUID = user.UUID
+	case "trojan":
+		p.Password = user.TrojanPassword
+	case "shadowsocks":
+		p.Password, p.Cipher = user.ShadowsocksPassword, inbound.Encryption
+	}
+
+	switch inbound.Security {
+	case "tls":
+		if tls := inbound.SecuritySettings.TLS; tls != nil {
+			p.SNI = tls.SNI
+			if p.SNI == "" {
+				p.SNI = tls.ServerName
+			}
+			p.Fingerprint = tls.Fingerprint
+			p.ALPN = tls.ALPN
+			p.AllowInsecure = tls.AllowInsecure
+		}
+	case "reality":
+		if reality := inbound.SecuritySettings.Reality; reality != nil {
+			if len(reality.ServerNames) > 0 {
+				p.SNI = reality.ServerNames[0]
+			}
+			if len(reality.ShortIDs) > 0 {
+				p.ShortID = reality.ShortIDs[0]
+			}
+			p.Fingerprint = reality.Fingerprint
+			p.PublicKey = reality.PublicKey
+			p.SpiderX = reality.SpiderX
+		}
+	}
+
+	s := inbound.NetworkSettings.Settings
+	switch p.Transport {
+	case "ws", "httpupgrade", "http", "xhttp":
+		p.Path = setting(s, "path")
+		p.Host = setting(s, "host")
+		p.Mode = setting(s, "mode")
+	case "grpc":
+		p.ServiceName = setting(s, "serviceName")
+		p.Host = setting(s, "authority")
+	case "kcp":
+		p.Seed = setting(s, "seed")
+		if header, ok := s["header"].(map[string]interface{}); ok {
+			p.HeaderType = setting(header, "type")
+			p.Host = setting(header, "domain")
+		}
+	case "tcp":
+		if header, ok := s["header"].(map[string]interface{}); ok && setting(header, "type") == "http" {
+			p.HeaderType = "http"
+			p.Path = setting(header, "path")
+			p.Host = setting(header, "host")
+		}
+	}
+
+	return p
+}
+
+// setting returns the given transport setting as a string, or the first one of a list.
+func setting(settings map[string]interface{}, key string) string {
+	switch value := settings[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []interface{}:
+		if len(value) > 0 {
+			return fmt.Sprint(value[0])
+		}
+		return ""
+	case []string:
+		if len(value) > 0 {
+			return value[0]
+		}
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,243 @@
+package subscription
+
+import (
+	"encoding/json"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Tags of the group and direct outbounds of the sing-box profile, which the route and DNS templates refer to.
+const (
+	SingBoxOutboundSelect = "proxy"
+	SingBoxOutboundAuto   = "auto"
+	SingBoxOutboundDirect = "direct"
+)
+
+// SingBoxDefaultDNS is the DNS section of the sing-box profiles when the settings have none.
+var SingBoxDefaultDNS = map[string]interface{}{
+	"servers": []interface{}{
+		map[string]interface{}{"type": "https", "tag": "remote", "server": "1.1.1.1", "detour": SingBoxOutboundSelect},
+		map[string]interface{}{"type": "local", "tag": "local"},
+	},
+	"final": "remote",
+}
+
+// SingBoxDefaultRoute is the route section of the sing-box profiles when the settings have none.
+var SingBoxDefaultRoute = map[string]interface{}{
+	"rules": []interface{}{
+		map[string]interface{}{"action": "sniff"},
+		map[string]interface{}{"protocol": "dns", "action": "hijack-dns"},
+		map[string]interface{}{"ip_is_private": true, "outbound": SingBoxOutboundDirect},
+	},
+	"final":                   SingBoxOutboundSelect,
+	"auto_detect_interface":   true,
+	"default_domain_resolver": "local",
+}
+
+type singBoxProfile struct {
+	Log       map[string]interface{} `json:"log"`
+	DNS       map[string]interface{} `json:"dns"`
+	Inbounds  []interface{}          `json:"inbounds"`
+	Outbounds []interface{}          `json:"outbounds"`
+	Route     map[string]interface{} `json:"route"`
+}
+
+type singBoxOutbound struct {
+	Type           string            `json:"type"`
+	Tag            string            `json:"tag"`
+	Server         string            `json:"server"`
+	ServerPort     int               `json:"server_port"`
+	UUID           string            `json:"uuid,omitempty"`
+	Security       string            `json:"security,omitempty"`
+	AlterId        *int              `json:"alter_id,omitempty"`
+	Password       string            `json:"password,omitempty"`
+	Method         string            `json:"method,omitempty"`
+	PacketEncoding string            `json:"packet_encoding,omitempty"`
+	TLS            *singBoxTLS       `json:"tls,omitempty"`
+	Transport      *singBoxTransport `json:"transport,omitempty"`
+}
+
+type singBoxTLS struct {
+	Enabled    bool            `json:"enabled"`
+	ServerName string          `json:"server_name,omitempty"`
+	Insecure   bool            `json:"insecure,omitempty"`
+	ALPN       []string        `json:"alpn,omitempty"`
+	Fragment   bool            `json:"fragment,omitempty"`
+	UTLS       *singBoxUTLS    `json:"utls,omitempty"`
+	Reality    *singBoxReality `json:"reality,omitempty"`
+}
+
+type singBoxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type singBoxReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortId   string `json:"short_id,omitempty"`
+}
+
+type singBoxTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`
+	Host        string            `json:"host,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+type singBoxGroup struct {
+	Type      string   `json:"type"`
+	Tag       string   `json:"tag"`
+	Outbounds []string `json:"outbounds"`
+	Default   string   `json:"default,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Interval  string   `json:"interval,omitempty"`
+}
+
+// SingBox renders the given proxies as a sing-box (1.12 or later) client configuration.
+// The outbounds are the proxies, a selector (SingBoxOutboundSelect), a urltest (SingBoxOutboundAuto) and a direct one
+// (SingBoxOutboundDirect). The DNS and route sections are the given templates, or the defaults when nil.
+// The inbounds are a TUN for the mobile apps and a local mixed proxy for the desktop.
+func SingBox(proxies []*Proxy, dns, route map[string]interface{}) ([]byte, error) {
+	if dns == nil {
+		dns = SingBoxDefaultDNS
+	}
+	if route == nil {
+		route = SingBoxDefaultRoute
+	}
+
+	var outbounds []interface{}
+	var tags []string
+	for _, p := range proxies {
+		if outbound := newSingBoxOutbound(p); outbound != nil {
+			outbounds = append(outbounds, outbound)
+			tags = append(tags, outbound.Tag)
+		}
+	}
+
+	groups := []interface{}{
+		&singBoxGroup{
+			Type:      "selector",
+			Tag:       SingBoxOutboundSelect,
+			Outbounds: append([]string{SingBoxOutboundAuto}, append(tags, SingBoxOutboundDirect)...),
+			Default:   SingBoxOutboundAuto,
+		},
+		&singBoxGroup{
+			Type:      "urltest",
+			Tag:       SingBoxOutboundAuto,
+			Outbounds: tags,
+			URL:       "https://www.gstatic.com/generate_204",
+			Interval:  "5m",
+		},
+	}
+	if len(tags) == 0 {
+		// sing-box refuses empty groups.
+		groups = groups[:1]
+		groups[0].(*singBoxGroup).Outbounds = []string{SingBoxOutboundDirect}
+		groups[0].(*singBoxGroup).Default = SingBoxOutboundDirect
+	}
+	outbounds = append(groups, outbounds...)
+	outbounds = append(outbounds, map[string]interface{}{"type": "direct", "tag": SingBoxOutboundDirect})
+
+	profile := &singBoxProfile{
+		Log: map[string]interface{}{"level": "warn"},
+		DNS: dns,
+		Inbounds: []interface{}{
+			map[string]interface{}{
+				"type":         "tun",
+				"tag":          "tun-in",
+				"address":      []string{"172.19.0.1/30", "fdfe:dcba:9876::1/126"},
+				"auto_route":   true,
+				"strict_route": true,
+				"stack":        "mixed",
+			},
+			map[string]interface{}{
+				"type":        "mixed",
+				"tag":         "mixed-in",
+				"listen":      "127.0.0.1",
+				"listen_port": 2080,
+			},
+		},
+		Outbounds: outbounds,
+		Route:     route,
+	}
+
+	content, err := json.MarshalIndent(profile, "", "  ")
+	return content, errors.WithStack(err)
+}
+
+// newSingBoxOutbound returns the sing-box outbound of the given proxy, or nil if sing-box does not support its transport.
+func newSingBoxOutbound(p *Proxy) *singBoxOutbound {
+	o := &singBoxOutbound{
+		Type:       p.Protocol,
+		Tag:        p.Name,
+		Server:     p.Server,
+		ServerPort: p.Port,
+	}
+
+	switch p.Protocol {
+	case "shadowsocks":
+		o.Method, o.Password = p.Cipher, p.Password
+		return o
+	case "vmess":
+		alterId := 0
+		o.UUID, o.Security, o.AlterId = p.UUID, p.Cipher, &alterId
+		if o.Security == "" {
+			o.Security = "auto"
+		}
+		o.PacketEncoding = "xudp"
+	case "vless":
+		o.UUID = p.UUID
+		o.PacketEncoding = "xudp"
+	case "trojan":
+		o.Password = p.Password
+	}
+
+	if p.TLS() {
+		o.TLS = &singBoxTLS{
+			Enabled:    true,
+			ServerName: p.SNI,
+			Insecure:   p.AllowInsecure,
+			ALPN:       p.ALPN,
+			// sing-box fragments the TLS handshakes without the fragment settings of Xray.
+			Fragment: p.Fragment != nil,
+		}
+		fingerprint := p.Fingerprint
+		if p.Security == "reality" {
+			o.TLS.Reality = &singBoxReality{Enabled: true, PublicKey: p.PublicKey, ShortId: p.ShortID}
+			// Reality needs uTLS in sing-box.
+			if fingerprint == "" {
+				fingerprint = "chrome"
+			}
+		}
+		if fingerprint != "" {
+			o.TLS.UTLS = &singBoxUTLS{Enabled: true, Fingerprint: fingerprint}
+		}
+	}
+
+	switch p.Transport {
+	case "tcp", "http":
+		// The nodes serve the http transport as TCP with the HTTP header obfuscation of Xray,
+		// which is not the HTTP transport of sing-box.
+		if p.Transport == "http" || p.HeaderType == "http" {
+			return nil
+		}
+	case "ws":
+		o.Transport = &singBoxTransport{Type: "ws", Path: p.Path}
+		if p.Host != "" {
+			o.Transport.Headers = map[string]string{"Host": p.Host}
+		}
+	case "httpupgrade":
+		o.Transport = &singBoxTransport{Type: "httpupgrade", Path: p.Path}
+		if p.Host != "" {
+			o.Transport.Host = p.Host
+		}
+	case "grpc":
+		o.Transport = &singBoxTransport{Type: "grpc", ServiceName: p.ServiceName}
+	default:
+		return nil
+	}
+
+	return o
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultFragment is the fragment of the inbounds with the fragment enabled and no fragment value:
+// the TLS client hello in pieces of 100-200 bytes, sent 10-20 milliseconds apart.
+const DefaultFragment = "tlshello,100-200,10-20"
+
+// Fragment is the fragment settings of the freedom outbounds of Xray, which the clients dial the nodes through.
+type Fragment struct {
+	Packets  string `json:"packets"`
+	Length   string `json:"length"`
+	Interval string `json:"interval"`
+}
+
+// ParseFragment parses the fragment value of an inbound, "packets,length,interval" like "tlshello,100-200,10-20" or
+// "1,40-60,30-50". The packets are "tlshello" or a range of packet numbers, the length is a range of bytes,
+// and the interval a range of milliseconds.
+func ParseFragment(value string) (*Fragment, error) {
+	parts := strings.Split(strings.ReplaceAll(value, " ", ""), ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("fragment must be packets,length,interval: %s", value)
+	}
+
+	f := &Fragment{Packets: parts[0], Length: parts[1], Interval: parts[2]}
+	if f.Packets != "tlshello" {
+		if err := checkRange(f.Packets, 1); err != nil {
+			return nil, fmt.Errorf("fragment packets: %w", err)
+		}
+	}
+	if err := checkRange(f.Length, 1); err != nil {
+		return nil, fmt.Errorf("fragment length: %w", err)
+	}
+	if err := checkRange(f.Interval, 0); err != nil {
+		return nil, fmt.Errorf("fragment interval: %w", err)
+	}
+	return f, nil
+}
+
+// checkRange returns an error if the given value is not a number or a range of numbers ("from-to") of at least min.
+func checkRange(value string, min int) error {
+	from, to, found := strings.Cut(value, "-")
+	if !found {
+		to = from
+	}
+
+	fromNumber, err := strconv.Atoi(from)
+	if err != nil {
+		return fmt.Errorf("invalid range: %s", value)
+	}
+	toNumber, err := strconv.Atoi(to)
+	if err != nil {
+		return fmt.Errorf("invalid range: %s", value)
+	}
+	if fromNumber < min || toNumber < fromNumber {
+		return fmt.Errorf("invalid range: %s", value)
+	}
+	return nil
+}
+
+// InboundFragment returns the fragment of an inbound with the given fragment settings (whether it is enabled and its
+// value), or nil if the fragment is disabled. Invalid fragment values, which are rejected when nodes are saved,
+// disable the fragment as well.
+func InboundFragment(enabled bool, value string) *Fragment {
+	if !enabled {
+		return nil
+	}
+
+	if value == "" {
+		value = DefaultFragment
+	}
+	f, err := ParseFragment(value)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// String returns the fragment in the form of the fragment values of the inbounds.
+func (f *Fragment) String() string {
+	return f.Packets + "," + f.Length + "," + f.Interval
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// QRLevels maps the QR error correction levels (L, M, Q and H) to the share of the code that can be recovered.
+var QRLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// QRPNG returns the QR code of the given content as a PNG image of the given width in pixels.
+// The level is one of the QRLevels.
+func QRPNG(content string, level string, size int) ([]byte, error) {
+	q, err := qrcode.New(content, QRLevels[level])
+	if err != nil {
+		return nil, err
+	}
+	return q.PNG(size)
+}
+
+// QRSVG returns the QR code of the given content as an SVG image of the given width in pixels.
+// The level is one of the QRLevels. The modules are drawn as a single path, so the image scales without blurring.
+func QRSVG(content string, level string, size int) (string, error) {
+	q, err := qrcode.New(content, QRLevels[level])
+	if err != nil {
+		return "", err
+	}
+
+	bitmap := q.Bitmap()
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				path.WriteString(fmt.Sprintf("M%d,%dh1v1h-1z", x, y))
+			}
+		}
+	}
+
+	return fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+			`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		size, size, len(bitmap), len(bitmap), path.String(),
+	), nil
+}
@@ -1,14 +1,20 @@
 package utils
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"math"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // FileExist checks if the given file path exists or not.
@@ -121,3 +127,153 @@ func PortsDistinct(ports []int) bool {
 	}
 	return true
 }
+
+// PortRange is a range of ports from From to To, a single port when they are equal.
+type PortRange struct {
+	From int
+	To   int
+}
+
+// ParsePortRange parses a port (e.g. "443") or a range of ports (e.g. "400:450", or "400-450" as Xray writes them).
+func ParsePortRange(s string) (PortRange, error) {
+	s = strings.TrimSpace(s)
+	from, to, found := strings.Cut(s, ":")
+	if !found {
+		from, to, found = strings.Cut(s, "-")
+	}
+	if !found {
+		to = from
+	}
+
+	r := PortRange{}
+	var err error
+	if r.From, err = strconv.Atoi(from); err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	if r.To, err = strconv.Atoi(to); err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	if r.From < 1 || r.To > 65535 || r.To < r.From {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return r, nil
+}
+
+// Single reports whether the range holds a single port.
+func (r PortRange) Single() bool {
+	return r.From == r.To
+}
+
+// Contains reports whether the given port is in the range.
+func (r PortRange) Contains(port int) bool {
+	return port >= r.From && port <= r.To
+}
+
+// Ports returns the ports of the range in order.
+func (r PortRange) Ports() []int {
+	ports := make([]int, 0, r.To-r.From+1)
+	for port := r.From; port <= r.To; port++ {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// String returns the range as "from:to", or the port of a single port range.
+func (r PortRange) String() string {
+	if r.Single() {
+		return strconv.Itoa(r.From)
+	}
+	return fmt.Sprintf("%d:%d", r.From, r.To)
+}
+
+// WriteFileAtomic writes data to a temporary file, syncs it and renames it over the given path,
+// so readers never observe a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+	if err = file.Chmod(perm); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+
+	return SyncDir(filepath.Dir(path))
+}
+
+// SyncDir flushes the directory entries (e.g., renames) of the given directory to the disk.
+func SyncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dir.Close()
+	}()
+	return dir.Sync()
+}
+
+// Encrypt seals the given data with AES-GCM using the 32-byte key, the random nonce is prepended to the result.
+func Encrypt(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decrypt opens the data sealed by Encrypt with the same key.
+func Decrypt(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// ParseDuration parses a Go duration (e.g. "12h") or a number of days (e.g. "30d").
+func ParseDuration(s string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(s, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
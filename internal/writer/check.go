@@ -0,0 +1,110 @@
+package writer
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/utils"
+)
+
+// MaxPortRange is the most ports that the server port range of an inbound holds, as the nodes listen on each of them
+// with an inbound of its own.
+const MaxPortRange = 100
+
+// CheckNode returns an error naming the first setting of the node that the node configurations cannot apply.
+// The nodes accept DNS servers and domain rules only, and rules must not match the domain of the reverse proxy, which
+// carries the traffic of the clients of the manager to the node, as its rule would shadow them.
+// The outbounds of the nodes have no source address, the inbounds need distinct tags without ":" (the tags of the
+// inbounds on the hop ports) and distinct ports (with the ports of their server port ranges of up to MaxPortRange
+// ports), TLS is not supported as the nodes cannot receive certificates, Reality is supported by VLESS and Trojan on
+// the TCP, HTTP, gRPC and XHTTP transports only, and only the TCP, HTTP, WebSocket and HTTPUpgrade transports accept
+// the PROXY protocol.
+func CheckNode(node *database.Node) error {
+	if len(node.DNSSettings.Hosts) > 0 {
+		return errors.New("dns: hosts are not supported by the nodes")
+	}
+	if node.DNSSettings.Tag != "" {
+		return errors.New("dns: tag is not supported by the nodes")
+	}
+
+	for i, r := range node.RoutingSettings.Rules {
+		if err := checkRule(node, r); err != nil {
+			return errors.Wrapf(err, "routing: rule %d", i+1)
+		}
+	}
+
+	if node.SendThrough != "" {
+		return errors.New("send_through is not supported by the nodes")
+	}
+
+	var ports []int
+	tags := map[string]bool{}
+	for i, in := range node.Inbounds {
+		if in.Tag == "" {
+			return errors.Errorf("inbound %d: tag is required", i+1)
+		}
+		if strings.Contains(in.Tag, ":") {
+			return errors.Errorf("inbound %q: tag must not contain \":\"", in.Tag)
+		}
+		if tags[in.Tag] {
+			return errors.Errorf("inbound %q: tag is not unique", in.Tag)
+		}
+		tags[in.Tag] = true
+
+		if err := checkInbound(in); err != nil {
+			return errors.Wrapf(err, "inbound %q", in.Tag)
+		}
+		ports = append(ports, in.ListeningPort)
+		ports = append(ports, in.HopPorts()...)
+	}
+	if !utils.PortsDistinct(ports) {
+		return errors.New("inbounds must listen on distinct ports")
+	}
+	return nil
+}
+
+// checkInbound returns an error naming the first setting of the given node inbound that the nodes cannot apply.
+func checkInbound(in *database.Inbound) error {
+	if in.ServerPort != "" {
+		r, err := utils.ParsePortRange(in.ServerPort)
+		if err != nil {
+			return errors.Wrap(err, "server_port")
+		}
+		if !r.Single() {
+			if r.To-r.From+1 > MaxPortRange {
+				return errors.Errorf("server_port: ranges hold at most %d ports", MaxPortRange)
+			}
+			if !r.Contains(in.ListeningPort) {
+				return errors.Errorf("listening_port %d is not in the server_port range %s", in.ListeningPort, r)
+			}
+		}
+	}
+
+	if in.Security == "tls" {
+		return errors.New("security tls is not supported by the nodes, which cannot receive certificates")
+	}
+	if in.Security == "reality" {
+		if in.Protocol == "vmess" {
+			return errors.New("security reality is not supported by the vmess protocol")
+		}
+		switch in.NetworkSettings.Transport {
+		case "ws", "kcp", "httpupgrade":
+			return errors.Errorf("security reality is not supported by the %s transport", in.NetworkSettings.Transport)
+		}
+	}
+
+	if in.NetworkSettings.AcceptProxyProtocol {
+		switch in.NetworkSettings.Transport {
+		case "grpc", "kcp", "xhttp":
+			return errors.Errorf("accept_proxy_protocol is not supported by the %s transport", in.NetworkSettings.Transport)
+		}
+	}
+
+	if in.Fragment && in.FragmentValue != "" {
+		if _, err := utils.ParseFragment(in.FragmentValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package writer
+
+import (
+	"fmt"
+
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-manager/internal/subscription"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/ebadidev/arch-node/pkg/xray"
+)
+
+// Tags of the Xray client configuration, which the routing rules of the settings refer to.
+const (
+	ClientOutboundProxy  = "proxy" // The balancer of the node outbounds
+	ClientOutboundDirect = "direct"
+	ClientOutboundBlock  = "block"
+)
+
+// clientFragmentTag returns the tag of the freedom outbound that the outbound of the given node inbound dials
+// through to fragment its connections.
+func clientFragmentTag(nodeId int, tag string) string {
+	return fmt.Sprintf("fragment-%d-%s", nodeId, tag)
+}
+
+// DefaultClientRules are the routing rules of the Xray client configurations when the settings have none.
+var DefaultClientRules = []database.RoutingRule{
+	{Type: "field", IP: []string{"geoip:private"}, OutboundTag: ClientOutboundDirect},
+	{Type: "field", Domain: []string{"geosite:private"}, OutboundTag: ClientOutboundDirect},
+}
+
+// ClientConfig is a complete Xray client configuration, with local SOCKS and HTTP inbounds and the nodes as outbounds.
+type ClientConfig struct {
+	Log         *xray.Log          `json:"log"`
+	Inbounds    []*clientInbound   `json:"inbounds"`
+	Outbounds   []*clientOutbound  `json:"outbounds"`
+	Routing     *clientRouting     `json:"routing"`
+	Observatory *clientObservatory `json:"observatory,omitempty"`
+}
+
+type clientInbound struct {
+	Tag      string                 `json:"tag"`
+	Protocol string                 `json:"protocol"`
+	Listen   string                 `json:"listen"`
+	Port     int                    `json:"port"`
+	Settings map[string]interface{} `json:"settings"`
+	Sniffing *clientSniffing        `json:"sniffing"`
+}
+
+type clientSniffing struct {
+	Enabled      bool     `json:"enabled"`
+	DestOverride []string `json:"destOverride"`
+}
+
+// clientOutbound is an outbound of the arch-node shapes, except for the VLESS settings, the Reality settings of the
+// client side and the socket options, which arch-node does not have.
+type clientOutbound struct {
+	Tag            string                `json:"tag"`
+	Protocol       string                `json:"protocol"`
+	Settings       interface{}           `json:"settings,omitempty"`
+	StreamSettings *clientStreamSettings `json:"streamSettings,omitempty"`
+}
+
+type clientStreamSettings struct {
+	*xray.StreamSettings
+	RealitySettings *clientRealitySettings `json:"realitySettings,omitempty"`
+	Sockopt         *clientSockopt         `json:"sockopt,omitempty"`
+}
+
+type clientSockopt struct {
+	DialerProxy string `json:"dialerProxy"`
+}
+
+type clientFreedomSettings struct {
+	Fragment *utils.Fragment `json:"fragment"`
+}
+
+type clientRealitySettings struct {
+	ServerName  string `json:"serverName"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"publicKey"`
+	ShortId     string `json:"shortId,omitempty"`
+	SpiderX     string `json:"spiderX,omitempty"`
+}
+
+type clientVlessSettings struct {
+	Vnext []*clientVlessServer `json:"vnext"`
+}
+
+type clientVlessServer struct {
+	Address string             `json:"address"`
+	Port    int                `json:"port"`
+	Users   []*clientVlessUser `json:"users"`
+}
+
+type clientVlessUser struct {
+	ID         string `json:"id"`
+	Encryption string `json:"encryption"`
+}
+
+type clientRouting struct {
+	DomainStrategy string            `json:"domainStrategy"`
+	Rules          []*clientRule     `json:"rules"`
+	Balancers      []*clientBalancer `json:"balancers,omitempty"`
+}
+
+type clientRule struct {
+	Type        string   `json:"type"`
+	Domain      []string `json:"domain,omitempty"`
+	IP          []string `json:"ip,omitempty"`
+	Port        string   `json:"port,omitempty"`
+	Network     string   `json:"network,omitempty"`
+	OutboundTag string   `json:"outboundTag,omitempty"`
+	BalancerTag string   `json:"balancerTag,omitempty"`
+}
+
+type clientBalancer struct {
+	Tag      string            `json:"tag"`
+	Selector []string          `json:"selector"`
+	Strategy map[string]string `json:"strategy"`
+}
+
+type clientObservatory struct {
+	SubjectSelector   []string `json:"subjectSelector"`
+	ProbeURL          string   `json:"probeURL"`
+	ProbeInterval     string   `json:"probeInterval"`
+	EnableConcurrency bool     `json:"enableConcurrency"`
+}
+
+// ClientConfig returns the Xray client configuration of the given user, with an outbound per node built with the stream
+// settings of the node inbounds, a least-ping balancer over them, and the routing rules of the settings.
+func (w *Writer) ClientConfig(user *database.User) *ClientConfig {
+	cc := &ClientConfig{
+		Log: &xray.Log{LogLevel: "warning"},
+		Inbounds: []*clientInbound{
+			{
+				Tag:      "socks",
+				Protocol: "socks",
+				Listen:   "127.0.0.1",
+				Port:     10808,
+				Settings: map[string]interface{}{"auth": "noauth", "udp": true},
+				Sniffing: &clientSniffing{Enabled: true, DestOverride: []string{"http", "tls", "quic"}},
+			},
+			{
+				Tag:      "http",
+				Protocol: "http",
+				Listen:   "127.0.0.1",
+				Port:     10809,
+				Settings: map[string]interface{}{},
+				Sniffing: &clientSniffing{Enabled: true, DestOverride: []string{"http", "tls"}},
+			},
+		},
+		Routing: &clientRouting{DomainStrategy: "IPIfNonMatch"},
+	}
+
+	var fragments []*clientOutbound
+	for _, p := range subscription.Proxies(w.database, user) {
+		o := w.clientOutbound(p)
+		if o.StreamSettings != nil && p.Fragment != nil {
+			o.StreamSettings.Sockopt = &clientSockopt{DialerProxy: clientFragmentTag(p.Node.Id, p.Inbound.Tag)}
+			fragments = append(fragments, &clientOutbound{
+				Tag:      clientFragmentTag(p.Node.Id, p.Inbound.Tag),
+				Protocol: "freedom",
+				Settings: &clientFreedomSettings{Fragment: p.Fragment},
+			})
+		}
+		cc.Outbounds = append(cc.Outbounds, o)
+	}
+
+	final := &clientRule{Type: "field", Network: "tcp,udp", BalancerTag: ClientOutboundProxy}
+	if len(cc.Outbounds) > 0 {
+		cc.Routing.Balancers = []*clientBalancer{{
+			Tag:      ClientOutboundProxy,
+			Selector: []string{ClientOutboundProxy + "-"},
+			Strategy: map[string]string{"type": "leastPing"},
+		}}
+		cc.Observatory = &clientObservatory{
+			SubjectSelector:   []string{ClientOutboundProxy + "-"},
+			ProbeURL:          "https://www.gstatic.com/generate_204",
+			ProbeInterval:     "5m",
+			EnableConcurrency: true,
+		}
+	} else {
+		final = &clientRule{Type: "field", Network: "tcp,udp", OutboundTag: ClientOutboundDirect}
+	}
+
+	cc.Outbounds = append(
+		cc.Outbounds,
+		&clientOutbound{Tag: ClientOutboundDirect, Protocol: "freedom"},
+		&clientOutbound{Tag: ClientOutboundBlock, Protocol: "blackhole"},
+	)
+	cc.Outbounds = append(cc.Outbounds, fragments...)
+
+	rules := w.database.Settings().XrayClient.Rules
+	if len(rules) == 0 {
+		rules = DefaultClientRules
+	}
+	for _, r := range rules {
+		rule := &clientRule{Type: r.Type, Domain: r.Domain, IP: r.IP, Port: r.Port, OutboundTag: r.OutboundTag}
+		if rule.Type == "" {
+			rule.Type = "field"
+		}
+		if rule.OutboundTag == ClientOutboundProxy {
+			rule.OutboundTag, rule.BalancerTag = final.OutboundTag, final.BalancerTag
+		}
+		cc.Routing.Rules = append(cc.Routing.Rules, rule)
+	}
+	cc.Routing.Rules = append(cc.Routing.Rules, final)
+
+	return cc
+}
+
+// clientOutbound returns the outbound of the given proxy, with the stream settings of the node inbound and the client
+// side of its TLS or Reality.
+func (w *Writer) clientOutbound(p *subscription.Proxy) *clientOutbound {
+	o := &clientOutbound{
+		Tag:      fmt.Sprintf("%s-%d-%s", ClientOutboundProxy, p.Node.Id, p.Inbound.Tag),
+		Protocol: p.Protocol,
+	}
+
+	switch p.Protocol {
+	case "shadowsocks":
+		o.Settings = &xray.OutboundSettings{Servers: []*xray.OutboundServer{{
+			Address:  p.Server,
+			Port:     p.Port,
+			Method:   p.Cipher,
+			Password: p.Password,
+			Uot:      true,
+		}}}
+		// Shadowsocks inbounds have no stream settings.
+		return o
+	case "vmess":
+		o.Settings = &xray.OutboundSettings{Vnext: []*xray.VnextServer{{
+			Address: p.Server,
+			Port:    p.Port,
+			Users:   []*xray.VmessUser{{ID: p.UUID, Security: p.Cipher}},
+		}}}
+	case "vless":
+		o.Settings = &clientVlessSettings{Vnext: []*clientVlessServer{{
+			Address: p.Server,
+			Port:    p.Port,
+			Users:   []*clientVlessUser{{ID: p.UUID, Encryption: "none"}},
+		}}}
+	case "trojan":
+		o.Settings = &xray.OutboundSettings{Servers: []*xray.OutboundServer{{
+			Address:  p.Server,
+			Port:     p.Port,
+			Password: p.Password,
+		}}}
+	}
+
+	streamSettings := w.createStreamSettings(p.Inbound)
+	if streamSettings == nil {
+		streamSettings = &xray.StreamSettings{Network: "tcp"}
+	}
+	o.StreamSettings = &clientStreamSettings{StreamSettings: streamSettings}
+
+	switch p.Security {
+	case "tls":
+		streamSettings.Security = "tls"
+		streamSettings.TlsSettings = &xray.TlsSettings{
+			ServerName:    p.SNI,
+			AllowInsecure: p.AllowInsecure,
+			Fingerprint:   p.Fingerprint,
+			Alpn:          p.ALPN,
+		}
+	case "reality":
+		streamSettings.Security = "reality"
+		o.StreamSettings.RealitySettings = &clientRealitySettings{
+			ServerName:  p.SNI,
+			Fingerprint: p.Fingerprint,
+			PublicKey:   p.PublicKey,
+			ShortId:     p.ShortID,
+			SpiderX:     p.SpiderX,
+		}
+		// Reality needs a uTLS fingerprint.
+		if o.StreamSettings.RealitySettings.Fingerprint == "" {
+			o.StreamSettings.RealitySettings.Fingerprint = "chrome"
+		}
+	}
+
+	return o
+}
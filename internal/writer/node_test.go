@@ -0,0 +1,373 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ebadidev/arch-manager/internal/config"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-node/pkg/xray"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// testTransports holds the network settings of the transports of the node inbounds.
+var testTransports = map[string]database.NetworkConfig{
+	"tcp": {Transport: "tcp"},
+	"http": {Transport: "http", Settings: map[string]interface{}{
+		"path": "/http", "host": []interface{}{"http.example.com"},
+	}},
+	"ws": {Transport: "ws", Settings: map[string]interface{}{
+		"path": "/ws", "host": "ws.example.com",
+	}},
+	"grpc": {Transport: "grpc", Settings: map[string]interface{}{
+		"serviceName": "service", "authority": "grpc.example.com",
+	}},
+	"kcp": {Transport: "kcp", Settings: map[string]interface{}{
+		"seed": "seed",
+	}},
+	"httpupgrade": {Transport: "httpupgrade", Settings: map[string]interface{}{
+		"path": "/upgrade", "host": "upgrade.example.com",
+	}},
+	"xhttp": {Transport: "xhttp", Settings: map[string]interface{}{
+		"path": "/xhttp", "host": "xhttp.example.com", "mode": "auto",
+	}},
+}
+
+func testWriter(nodes ...*database.Node) *Writer {
+	c := &config.Config{}
+	c.Xray.LogLevel = "warning"
+	d := &database.Database{Content: &database.Content{
+		Settings: &database.Settings{Host: "manager.example.com"},
+		Users: []*database.User{{
+			Id:                  1,
+			Enabled:             true,
+			UUID:                "b831381d-6324-4d53-ad4f-8cda48b30811",
+			TrojanPassword:      "trojan-password",
+			ShadowsocksPassword: "dXNlci1rZXktMTZieXRlcw==",
+		}},
+		Nodes: nodes,
+	}}
+	return New(c, d, xray.New(nil, nil, "warning", "", ""))
+}
+
+func testInbound(protocol, transport, security string) *database.Inbound {
+	in := &database.Inbound{
+		Tag:             "inbound",
+		Protocol:        protocol,
+		ServerPort:      "443",
+		Encryption:      "none",
+		ListeningIP:     "0.0.0.0",
+		ListeningPort:   443,
+		NetworkSettings: testTransports[transport],
+		Security:        security,
+		CertMode:        "none",
+	}
+	switch protocol {
+	case "vmess":
+		in.Encryption = "auto"
+	case "shadowsocks":
+		in.Encryption = config.Shadowsocks2022Method
+	}
+	switch security {
+	case "tls":
+		in.CertMode = "http"
+		in.SecuritySettings.TLS = &database.TLSConfig{
+			ServerName: "tls.example.com",
+			ALPN:       []string{"h2", "http/1.1"},
+		}
+	case "reality":
+		in.SecuritySettings.Reality = &database.RealityConfig{
+			Dest:        "www.example.com:443",
+			PrivateKey:  "gOsUq4IuIjcu2lt1k2YEvRDudDlHWrbaJ7ZRb7IyN1w",
+			ShortIDs:    []string{"abcd"},
+			ServerNames: []string{"www.example.com"},
+		}
+	}
+	return in
+}
+
+func TestRemoteConfigInbounds(t *testing.T) {
+	securities := map[string][]string{
+		"shadowsocks": {"none"},
+		"vmess":       {"none", "tls"},
+		"vless":       {"none", "tls", "reality"},
+		"trojan":      {"none", "tls", "reality"},
+	}
+
+	for protocol, protocolSecurities := range securities {
+		for transport := range testTransports {
+			if protocol == "shadowsocks" && transport != "tcp" {
+				continue
+			}
+			for _, security := range protocolSecurities {
+				if security == "reality" && (transport == "ws" || transport == "kcp" || transport == "httpupgrade") {
+					continue
+				}
+				name := fmt.Sprintf("%s-%s-%s", protocol, transport, security)
+				t.Run(name, func(t *testing.T) {
+					node := &database.Node{
+						Id:         1,
+						ServerAddr: "node.example.com",
+						Inbounds:   []*database.Inbound{testInbound(protocol, transport, security)},
+					}
+					xc := testWriter(node).RemoteConfig(node, time.Unix(0, 0), "bm9kZS1rZXktMTZieXRlcw==")
+
+					// The nodes cannot receive the certificates of TLS inbounds
+					if security == "tls" {
+						if err := CheckNode(node); err == nil {
+							t.Error("got no error for tls")
+						}
+						if err := Validate(xc); err == nil {
+							t.Error("got a valid config with tls")
+						}
+						lc, err := testWriter(node).LocalConfig()
+						if err != nil {
+							t.Fatal(err)
+						}
+						if err = Validate(lc); err == nil {
+							t.Error("got a valid local config with tls")
+						}
+						return
+					}
+
+					if err := CheckNode(node); err != nil {
+						t.Fatal(err)
+					}
+					if err := Validate(xc); err != nil {
+						t.Fatalf("invalid config: %v", err)
+					}
+
+					var inbound *xray.Inbound
+					for _, i := range xc.Inbounds {
+						if i.Tag == RemoteInboundTag(node, node.Inbounds[0]) {
+							inbound = i
+						}
+					}
+					if inbound == nil {
+						t.Fatal("got no inbound")
+					}
+					got, err := json.MarshalIndent(inbound, "", "  ")
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, '\n')
+
+					golden := filepath.Join("testdata", name+".json")
+					if *update {
+						if err = os.WriteFile(golden, got, 0644); err != nil {
+							t.Fatal(err)
+						}
+					}
+					want, err := os.ReadFile(golden)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, want) {
+						t.Errorf("got:\n%s\nwant:\n%s", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCheckNodeReality(t *testing.T) {
+	for _, in := range []*database.Inbound{testInbound("vmess", "tcp", "reality"), testInbound("vless", "ws", "reality")} {
+		node := &database.Node{Inbounds: []*database.Inbound{in}}
+		if err := CheckNode(node); err == nil {
+			t.Errorf("got no error for %s on %s with Reality", in.Protocol, in.NetworkSettings.Transport)
+		}
+	}
+}
+
+func TestRemoteConfigRoutingAndDNS(t *testing.T) {
+	node := &database.Node{
+		Id:          1,
+		ServerAddr:  "node.example.com",
+		DNSSettings: database.DNSConfig{Servers: []string{"1.1.1.1"}},
+		RoutingSettings: database.RoutingConfig{Rules: []database.RoutingRule{
+			{Domain: []string{"domain:example.com"}, OutboundTag: NodeOutboundDirect},
+			{Domain: []string{"geosite:category-ads-all"}, OutboundTag: NodeOutboundBlock},
+		}},
+		Inbounds: []*database.Inbound{testInbound("vless", "tcp", "none")},
+	}
+	if err := CheckNode(node); err != nil {
+		t.Fatal(err)
+	}
+
+	// The nodes have no IP and port conditions in the rules and no hosts and tag in the DNS settings
+	unsupported := map[string]func(n *database.Node){
+		"ip": func(n *database.Node) {
+			n.RoutingSettings.Rules = []database.RoutingRule{{IP: []string{"10.0.0.0/8"}, OutboundTag: NodeOutboundBlock}}
+		},
+		"port": func(n *database.Node) {
+			n.RoutingSettings.Rules = []database.RoutingRule{{Port: "25", OutboundTag: NodeOutboundBlock}}
+		},
+		"hosts": func(n *database.Node) { n.DNSSettings.Hosts = map[string]string{"example.com": "1.2.3.4"} },
+		"tag":   func(n *database.Node) { n.DNSSettings.Tag = "dns" },
+	}
+	for name, change := range unsupported {
+		n := *node
+		change(&n)
+		if err := CheckNode(&n); err == nil {
+			t.Errorf("got no error for %s", name)
+		}
+	}
+
+	nc := testWriter(node).RemoteConfig(node, time.Unix(0, 0), "password")
+	if err := Validate(nc); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+
+	xc := readNodeConfig(t, nc)
+	if xc.DNS == nil || len(xc.DNS.Servers) != 1 || xc.DNS.Servers[0] != "1.1.1.1" {
+		t.Errorf("got the DNS settings %+v", xc.DNS)
+	}
+
+	rules := xc.Routing.Rules
+	if len(rules) < 3 {
+		t.Fatalf("got %d rules", len(rules))
+	}
+	direct, block, final := rules[len(rules)-3], rules[len(rules)-2], rules[len(rules)-1]
+	if len(direct.Domain) != 1 || direct.Domain[0] != "domain:example.com" || direct.OutboundTag != "out" {
+		t.Errorf("got the direct rule %+v", direct)
+	}
+	if len(block.Domain) != 1 || block.OutboundTag != NodeOutboundBlock || len(block.InboundTag) == 0 {
+		t.Errorf("got the block rule %+v", block)
+	}
+	if final.OutboundTag != "out" || len(final.Domain) > 0 {
+		t.Errorf("got the final rule %+v", final)
+	}
+}
+
+func TestRemoteConfigOutbounds(t *testing.T) {
+	fragmented := testInbound("vless", "tcp", "reality")
+	fragmented.Tag = "fragmented"
+	fragmented.Fragment = true
+	plain := testInbound("trojan", "tcp", "reality")
+	plain.ListeningPort, plain.ServerPort = 8443, "8443"
+	node := &database.Node{
+		Id:          1,
+		ServerAddr:  "node.example.com",
+		SendThrough: "203.0.113.11",
+		Inbounds:    []*database.Inbound{fragmented, plain},
+	}
+	if err := CheckNode(node); err == nil {
+		t.Error("got no error for send_through")
+	}
+	node.SendThrough = ""
+	if err := CheckNode(node); err != nil {
+		t.Fatal(err)
+	}
+
+	nc := testWriter(node).RemoteConfig(node, time.Unix(0, 0), "password")
+	if err := Validate(nc); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+
+	// The clients fragment their connections, so the traffic of every inbound goes out of the node alike
+	xc := readNodeConfig(t, nc)
+	for _, o := range xc.Outbounds {
+		if o.Protocol == "freedom" && o.Tag != "out" {
+			t.Errorf("got the freedom outbound %s", o.Tag)
+		}
+	}
+	routes := map[string]string{}
+	for _, r := range xc.Routing.Rules {
+		for _, tag := range r.InboundTag {
+			routes[tag] = r.OutboundTag
+		}
+	}
+	for _, in := range node.Inbounds {
+		if routes[RemoteInboundTag(node, in)] != "out" {
+			t.Errorf("got the inbound %s routed to %s", in.Tag, routes[RemoteInboundTag(node, in)])
+		}
+	}
+}
+
+func TestRemoteConfigPortRange(t *testing.T) {
+	in := testInbound("vless", "tcp", "none")
+	in.ServerPort = "20000:20009"
+	in.ListeningPort = 20005
+	node := &database.Node{Id: 1, ServerAddr: "node.example.com", Inbounds: []*database.Inbound{in}}
+	if err := CheckNode(node); err != nil {
+		t.Fatal(err)
+	}
+
+	nc := testWriter(node).RemoteConfig(node, time.Unix(0, 0), "password")
+	if err := Validate(nc); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+
+	ports := map[int]string{}
+	for _, i := range readNodeConfig(t, nc).Inbounds {
+		if IsRemoteInboundTag(i.Tag) {
+			ports[i.Port] = i.Tag
+		}
+	}
+	if len(ports) != 10 {
+		t.Fatalf("got the inbounds %v, want one on each port of the range", ports)
+	}
+	for port := 20000; port <= 20009; port++ {
+		want := fmt.Sprintf("%s:%d", RemoteInboundTag(node, in), port)
+		if port == in.ListeningPort {
+			want = RemoteInboundTag(node, in)
+		}
+		if ports[port] != want {
+			t.Errorf("got the inbound %q on %d, want %q", ports[port], port, want)
+		}
+	}
+
+	in.ServerPort = "20000:20100"
+	if err := CheckNode(node); err == nil {
+		t.Errorf("got no error for a range of %d ports", 101)
+	}
+}
+
+func TestRemoteInboundTag(t *testing.T) {
+	first, second := testInbound("vless", "tcp", "none"), testInbound("trojan", "tcp", "none")
+	second.Tag, second.ListeningPort, second.ServerPort = "second", 8443, "8443"
+	node := &database.Node{Id: 1, ServerAddr: "node.example.com", Inbounds: []*database.Inbound{first, second}}
+
+	nc := testWriter(node).RemoteConfig(node, time.Unix(0, 0), "password")
+	tags := map[string]int{}
+	for _, i := range readNodeConfig(t, nc).Inbounds {
+		tags[i.Tag] = i.Port
+	}
+	// The nodes keep the port of the "remote" inbound bound across the pushes
+	if tags["remote"] != first.ListeningPort || tags["remote-second"] != second.ListeningPort {
+		t.Errorf("got the inbounds %v, want remote on %d and remote-second on %d", tags, first.ListeningPort, second.ListeningPort)
+	}
+
+	for tag, want := range map[string]bool{"remote": true, "remote-second": true, "remote:20001": true, "direct": false, "api": false} {
+		if IsRemoteInboundTag(tag) != want {
+			t.Errorf("got %v for %s, want %v", !want, tag, want)
+		}
+	}
+}
+
+// readNodeConfig returns the given node configuration as the nodes read it, into the Xray configuration of arch-node,
+// failing the test when the nodes would reject it.
+func readNodeConfig(t *testing.T, config interface{}) *xray.Config {
+	t.Helper()
+
+	content, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var xc xray.Config
+	if err = json.Unmarshal(content, &xc); err != nil {
+		t.Fatalf("the node cannot read the config: %v", err)
+	}
+	if err = xc.Validate(); err != nil {
+		t.Fatalf("the node rejects the config: %v", err)
+	}
+	return &xc
+}
@@ -0,0 +1,106 @@
+package writer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/database"
+	"github.com/ebadidev/arch-node/pkg/xray"
+)
+
+// Outbound tags of the routing rules of the nodes.
+// The node sends the traffic of "direct" to its freedom outbound and drops the traffic of "block".
+const (
+	NodeOutboundDirect = "direct"
+	NodeOutboundBlock  = "block"
+)
+
+// reverseProxyDomain returns the domain of the reverse proxy between the manager and the node with the given id.
+func reverseProxyDomain(nodeId int) string {
+	return fmt.Sprintf("s%d.reverse.proxy", nodeId)
+}
+
+// checkRule returns an error if the node cannot apply the given routing rule.
+func checkRule(node *database.Node, r database.RoutingRule) error {
+	if r.Type != "" && r.Type != "field" {
+		return errors.Errorf("unsupported type: %s", r.Type)
+	}
+	if len(r.IP) > 0 {
+		return errors.New("ip is not supported by the nodes")
+	}
+	if r.Port != "" {
+		return errors.New("port is not supported by the nodes")
+	}
+	if r.OutboundTag != NodeOutboundDirect && r.OutboundTag != NodeOutboundBlock {
+		return errors.Errorf("outbound tag must be %q or %q: %s", NodeOutboundDirect, NodeOutboundBlock, r.OutboundTag)
+	}
+
+	for _, domain := range r.Domain {
+		matched, err := matchDomain(domain, reverseProxyDomain(node.Id))
+		if err != nil {
+			return errors.Wrapf(err, "domain %q", domain)
+		}
+		if matched {
+			return errors.Errorf("domain %q matches the reverse proxy domain %s", domain, reverseProxyDomain(node.Id))
+		}
+	}
+	return nil
+}
+
+// matchDomain reports whether the given domain rule of Xray matches the given domain.
+// Rules of geosite and external files are not loaded, and never match the internal domains.
+func matchDomain(rule, domain string) (bool, error) {
+	rule = strings.ToLower(rule)
+	kind, value, found := strings.Cut(rule, ":")
+	if !found {
+		return strings.Contains(domain, rule), nil
+	}
+
+	switch kind {
+	case "full":
+		return domain == value, nil
+	case "domain":
+		return domain == value || strings.HasSuffix(domain, "."+value), nil
+	case "keyword":
+		return strings.Contains(domain, value), nil
+	case "regexp":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		return re.MatchString(domain), nil
+	case "dotless":
+		return !strings.Contains(domain, ".") && strings.Contains(domain, value), nil
+	case "geosite", "ext":
+		return false, nil
+	default:
+		return strings.Contains(domain, rule), nil
+	}
+}
+
+// nodeDNS returns the DNS settings of the node, or the default ones when the node has no servers.
+func nodeDNS(node *database.Node, defaults *xray.DNS) *xray.DNS {
+	if len(node.DNSSettings.Servers) == 0 {
+		return defaults
+	}
+	return &xray.DNS{Servers: node.DNSSettings.Servers}
+}
+
+// nodeRules returns the routing rules of the node for the traffic of the given inbounds.
+// The rules that the node cannot apply are skipped, as CheckNode rejects them on save.
+func nodeRules(node *database.Node, inboundTags []string) []*xray.Rule {
+	var rules []*xray.Rule
+	for _, r := range node.RoutingSettings.Rules {
+		if checkRule(node, r) != nil {
+			continue
+		}
+		rule := &xray.Rule{InboundTag: inboundTags, Domain: r.Domain, OutboundTag: "out"}
+		if r.OutboundTag == NodeOutboundBlock {
+			rule.OutboundTag = NodeOutboundBlock
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
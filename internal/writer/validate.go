@@ -0,0 +1,100 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ebadidev/arch-manager/internal/utils"
+	"github.com/ebadidev/arch-node/pkg/xray"
+	"github.com/xtls/xray-core/infra/conf/serial"
+)
+
+// Validate runs the given configuration through the config loader of Xray, which the cores run on start, so
+// configurations that would stop the cores are caught before they are applied. The configuration has the arch-node
+// shapes that the manager and the nodes read, whose TLS settings carry no certificates, so TLS inbounds are rejected
+// as the cores could not serve them.
+// The geo files (geosite.dat and geoip.dat) are next to the cores only, so the rules of geo files are left to them.
+func Validate(config *xray.Config) error {
+	for _, i := range config.Inbounds {
+		if i.StreamSettings != nil && i.StreamSettings.Security == "tls" {
+			return errors.Errorf("inbound %s: tls has no certificates", i.Tag)
+		}
+	}
+	content, err := json.Marshal(config)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var fields map[string]interface{}
+	if err = json.Unmarshal(content, &fields); err != nil {
+		return errors.WithStack(err)
+	}
+	removeGeoRules(fields)
+	if content, err = json.Marshal(fields); err != nil {
+		return errors.WithStack(err)
+	}
+
+	xc, err := serial.DecodeJSONConfig(bytes.NewReader(content))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = xc.Build()
+	return errors.WithStack(err)
+}
+
+// removeGeoRules removes the domains and the IPs of the routing rules of the given configuration fields that refer to
+// geo files.
+func removeGeoRules(fields map[string]interface{}) {
+	routing, _ := fields["routing"].(map[string]interface{})
+	rules, _ := routing["rules"].([]interface{})
+	for _, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		for key, prefix := range map[string]string{"domain": "geosite:", "ip": "geoip:"} {
+			values, ok := rule[key].([]interface{})
+			if !ok {
+				continue
+			}
+
+			var kept []interface{}
+			for _, value := range values {
+				if v, _ := value.(string); strings.HasPrefix(v, prefix) || strings.HasPrefix(v, "ext:") {
+					continue
+				}
+				kept = append(kept, value)
+			}
+			if len(kept) > 0 {
+				rule[key] = kept
+			} else {
+				delete(rule, key)
+			}
+		}
+	}
+}
+
+// RejectedConfig is a configuration that failed the validation, stored with the error for the admin to inspect.
+type RejectedConfig struct {
+	Error      string      `json:"error"`
+	RejectedAt int64       `json:"rejected_at"`
+	Config     interface{} `json:"config"`
+}
+
+// SaveRejectedConfig stores the given configuration that failed the validation, by the name of its core
+// ("local" or "node-<id>"). The last rejected configuration of each core is kept.
+func (w *Writer) SaveRejectedConfig(name string, config interface{}, reason error) error {
+	content, err := json.MarshalIndent(&RejectedConfig{
+		Error:      reason.Error(),
+		RejectedAt: time.Now().UnixMilli(),
+		Config:     config,
+	}, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = utils.WriteFileAtomic(fmt.Sprintf(w.c.Env.XrayRejectedConfigPath, name), content, 0600)
+	return errors.WithStack(err)
+}
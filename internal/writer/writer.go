@@ -3,6 +3,7 @@ package writer
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -20,32 +21,116 @@ type Writer struct {
 	xray     *xray.Xray
 }
 
-func (w *Writer) clients() []*xray.Client {
+// ClientEmail returns the email of the user in the client inbound of the node with the given id.
+// Xray reports the stats of each user by email, so the local inbounds of the nodes use distinct emails to tell the nodes apart.
+// Remote nodes report their stats separately and use the user id alone (node id 0).
+func ClientEmail(userId, nodeId int) string {
+	if nodeId == 0 {
+		return strconv.Itoa(userId)
+	}
+	return fmt.Sprintf("%d@node-%d", userId, nodeId)
+}
+
+// RemoteInboundTag returns the tag of the given inbound of the node in the configuration of the node: "remote" for the
+// first inbound, the one of the nodes of a single inbound before the inbound lists, and "remote-<tag>" for the others.
+// The nodes report the traffic of each inbound by this tag, and accept configurations whose "remote" inbound keeps the
+// port it is listening on, while the ports of the other inbounds must be free.
+func RemoteInboundTag(node *database.Node, in *database.Inbound) string {
+	if len(node.Inbounds) > 0 && node.Inbounds[0] == in {
+		return "remote"
+	}
+	return "remote-" + in.Tag
+}
+
+// IsRemoteInboundTag reports whether the given tag is the one of an inbound in the configuration of a node, including
+// the inbounds on the hop ports of the node inbounds.
+func IsRemoteInboundTag(tag string) bool {
+	tag, _, _ = strings.Cut(tag, ":")
+	return tag == "remote" || strings.HasPrefix(tag, "remote-")
+}
+
+// localInboundTag returns the tag of the node inbound with the given tag in the local configuration,
+// which serves it for the node with the given id.
+func localInboundTag(nodeId int, tag string) string {
+	return fmt.Sprintf("client-%d-%s", nodeId, tag)
+}
+
+// hopInbounds returns copies of the given Xray inbound of the node inbound on the hop ports of the node inbound,
+// tagged "<tag>:<port>", as the inbounds of the nodes listen on a single port each.
+func hopInbounds(in *database.Inbound, inbound *xray.Inbound) []*xray.Inbound {
+	var inbounds []*xray.Inbound
+	for _, port := range in.HopPorts() {
+		hop := *inbound
+		hop.Port = port
+		hop.Tag = fmt.Sprintf("%s:%d", inbound.Tag, port)
+		inbounds = append(inbounds, &hop)
+	}
+	return inbounds
+}
+
+// retiredClientEmail returns the email of a retired credential of the user, which must differ from the current one.
+func retiredClientEmail(email string, index int) string {
+	return fmt.Sprintf("%s~%d", email, index)
+}
+
+// ParseClientEmail returns the user id and the node id of the given client email, or false if it is not one.
+func ParseClientEmail(email string) (userId, nodeId int, ok bool) {
+	email, _, _ = strings.Cut(email, "~")
+	user, node, found := strings.Cut(email, "@node-")
+	userId, err := strconv.Atoi(user)
+	if err != nil {
+		return 0, 0, false
+	}
+	if found {
+		if nodeId, err = strconv.Atoi(node); err != nil {
+			return 0, 0, false
+		}
+	}
+	return userId, nodeId, true
+}
+
+// clients returns the enabled users as the clients of an inbound of the given protocol, with the credentials stored in
+// each user, which the profile links use as well. Retired credentials in their grace period are extra clients of their user.
+func (w *Writer) clients(protocol string, nodeId int) []*xray.Client {
+	now := time.Now()
 	var clients []*xray.Client
-	for _, u := range w.database.Content.Users {
+	for _, u := range w.database.Users() {
 		if !u.Enabled {
 			continue
 		}
-		clients = append(clients, &xray.Client{
-			Email:    strconv.Itoa(u.Id),
-			Password: u.ShadowsocksPassword,
+		email := ClientEmail(u.Id, nodeId)
+		switch protocol {
+		case "vless", "vmess":
+			clients = append(clients, &xray.Client{Email: email, ID: u.UUID})
+			for i, id := range u.RetiredCredentials(database.CredentialUUID, now) {
+				clients = append(clients, &xray.Client{Email: retiredClientEmail(email, i), ID: id})
+			}
+		case "trojan":
+			clients = append(clients, &xray.Client{Email: email, Password: u.TrojanPassword})
+			for i, password := range u.RetiredCredentials(database.CredentialTrojan, now) {
+				clients = append(clients, &xray.Client{Email: retiredClientEmail(email, i), Password: password})
+			}
+		default:
 			// Note: For Shadowsocks 2022 multi-user, method must be empty for individual users
-			Method:   "",
-		})
+			clients = append(clients, &xray.Client{Email: email, Password: u.ShadowsocksPassword})
+			for i, password := range u.RetiredCredentials(database.CredentialShadowsocks, now) {
+				clients = append(clients, &xray.Client{Email: retiredClientEmail(email, i), Password: password})
+			}
+		}
 	}
 	return clients
 }
 
 // Protocol factory method - supports all protocols via arch-node package
-func (w *Writer) makeProtocolInbound(node *database.Node, tag, password, network string, port int, clients []*xray.Client) (*xray.Inbound, error) {
+func (w *Writer) makeProtocolInbound(in *database.Inbound, tag, password, network string, port int, clients []*xray.Client) (*xray.Inbound, error) {
 	xc := xray.NewConfig(w.c.Xray.LogLevel)
 	
 	var inbound *xray.Inbound
 	
-	// Create StreamSettings based on node configuration
-	streamSettings := w.createStreamSettings(node)
+	// Create StreamSettings based on inbound configuration
+	streamSettings := w.addSecuritySettings(in, w.createStreamSettings(in))
 	
-	switch node.Protocol {
+	switch in.Protocol {
 	case "shadowsocks":
 		// For Shadowsocks, generate a proper password if not provided
 		if password == "" {
@@ -57,65 +142,155 @@ func (w *Writer) makeProtocolInbound(node *database.Node, tag, password, network
 		}
 		// Note: Shadowsocks method still uses network parameter, not StreamSettings
 		transport := network
-		if node.NetworkSettings.Transport != "" {
-			transport = node.NetworkSettings.Transport
+		if in.NetworkSettings.Transport != "" {
+			transport = in.NetworkSettings.Transport
 		}
-		inbound = xc.MakeShadowsocksInbound(tag, password, node.Encryption, transport, port, clients)
+		inbound = xc.MakeShadowsocksInbound(tag, password, in.Encryption, transport, port, clients)
 	case "vless":
-		// For VLESS, generate a proper UUID
-		uuid := utils.UUID()
-		// Based on test: MakeVlessInbound("test", 10001, "uuid", "tcp", nil)
 		// Signature: tag, port, uuid, network, streamSettings
+		// The placeholder client is replaced with the users, identified by their UUID
 		network := "tcp"
-		if node.NetworkSettings.Transport != "" {
-			network = node.NetworkSettings.Transport
+		if in.NetworkSettings.Transport != "" {
+			network = in.NetworkSettings.Transport
 		}
-		inbound = xc.MakeVlessInbound(tag, port, uuid, network, streamSettings)
+		inbound = xc.MakeVlessInbound(tag, port, "", network, streamSettings)
+		inbound.Settings.Clients = clients
 	case "vmess":
-		// For VMess, generate a proper UUID
-		uuid := utils.UUID()
-		// Based on test: MakeVmessInbound("test", 10002, "uuid", "auto", nil)
 		// Signature: tag, port, uuid, encryption, streamSettings
-		inbound = xc.MakeVmessInbound(tag, port, uuid, node.Encryption, streamSettings)
+		// The placeholder client is replaced with the users, identified by their UUID
+		inbound = xc.MakeVmessInbound(tag, port, "", in.Encryption, streamSettings)
+		inbound.Settings.Clients = clients
 	case "trojan":
-		// Based on test: MakeTrojanInbound("test", 10003, "password", "tcp", nil)
 		// Signature: tag, port, password, network, streamSettings
+		// The placeholder client is replaced with the users, identified by their Trojan password,
+		// and the stream settings are set here as MakeTrojanInbound ignores them
 		network := "tcp"
-		if node.NetworkSettings.Transport != "" {
-			network = node.NetworkSettings.Transport
+		if in.NetworkSettings.Transport != "" {
+			network = in.NetworkSettings.Transport
 		}
 		inbound = xc.MakeTrojanInbound(tag, port, password, network, streamSettings)
+		inbound.Settings.Clients = clients
+		inbound.StreamSettings = streamSettings
 	default:
-		return nil, fmt.Errorf("unsupported protocol: %s", node.Protocol)
+		return nil, fmt.Errorf("unsupported protocol: %s", in.Protocol)
 	}
 	
 	return inbound, nil
 }
 
-// Create StreamSettings based on node network configuration
-func (w *Writer) createStreamSettings(node *database.Node) *xray.StreamSettings {
-	if node.NetworkSettings.Transport == "" || node.NetworkSettings.Transport == "tcp" {
+// Create StreamSettings based on inbound network configuration
+func (w *Writer) createStreamSettings(in *database.Inbound) *xray.StreamSettings {
+	if in.NetworkSettings.Transport == "" || in.NetworkSettings.Transport == "tcp" {
 		return nil // No special transport needed for TCP
 	}
 	
-	switch node.NetworkSettings.Transport {
+	switch in.NetworkSettings.Transport {
 	case "ws":
-		return w.createWebSocketSettings(node.NetworkSettings.Settings)
+		return w.createWebSocketSettings(in.NetworkSettings.Settings)
 	case "grpc":
-		return w.createGrpcSettings(node.NetworkSettings.Settings)
+		return w.createGrpcSettings(in.NetworkSettings.Settings)
 	case "http":
-		return w.createHttpSettings(node.NetworkSettings.Settings)
+		return w.createHttpSettings(in.NetworkSettings.Settings)
 	case "kcp":
-		return w.createKcpSettings(node.NetworkSettings.Settings)
+		return w.createKcpSettings(in.NetworkSettings.Settings)
 	case "httpupgrade":
-		return w.createHttpUpgradeSettings(node.NetworkSettings.Settings)
+		return w.createHttpUpgradeSettings(in.NetworkSettings.Settings)
 	case "xhttp":
-		return w.createXhttpSettings(node.NetworkSettings.Settings)
+		return w.createXhttpSettings(in.NetworkSettings.Settings)
 	default:
 		// For unknown transports, create basic StreamSettings
 		return &xray.StreamSettings{
-			Network: node.NetworkSettings.Transport,
+			Network: in.NetworkSettings.Transport,
+		}
+	}
+}
+
+// addSecuritySettings sets the TLS or Reality settings of the node inbound on the given stream settings,
+// creating TCP stream settings when the transport needs none. Shadowsocks has no stream settings,
+// and VMess does not support Reality, which CheckNode rejects, so it is served without security as its links advertise.
+// The TLS settings of arch-node carry no certificates, so CheckNode rejects TLS inbounds and Validate rejects the
+// configurations of the ones saved before.
+func (w *Writer) addSecuritySettings(in *database.Inbound, streamSettings *xray.StreamSettings) *xray.StreamSettings {
+	if in.Protocol == "shadowsocks" {
+		return streamSettings
+	}
+
+	switch in.Security {
+	case "tls":
+		if streamSettings == nil {
+			streamSettings = &xray.StreamSettings{Network: "tcp"}
+		}
+		streamSettings.Security = "tls"
+		streamSettings.TlsSettings = &xray.TlsSettings{}
+		if tls := in.SecuritySettings.TLS; tls != nil {
+			streamSettings.TlsSettings = &xray.TlsSettings{
+				ServerName:         tls.ServerName,
+				RejectUnknownSni:   tls.RejectUnknownSni,
+				AllowInsecure:      tls.AllowInsecure,
+				Fingerprint:        tls.Fingerprint,
+				Sni:                tls.SNI,
+				CurvePreferences:   tls.CurvePreferences,
+				Alpn:               tls.ALPN,
+				ServerNameToVerify: tls.ServerNameToVerify,
+			}
+		}
+	case "reality":
+		if in.Protocol == "vmess" {
+			return streamSettings
+		}
+		if streamSettings == nil {
+			streamSettings = &xray.StreamSettings{Network: "tcp"}
+		}
+		streamSettings.Security = "reality"
+		streamSettings.RealitySettings = &xray.RealitySettings{}
+		if reality := in.SecuritySettings.Reality; reality != nil {
+			streamSettings.RealitySettings = &xray.RealitySettings{
+				Show:          reality.Show,
+				Dest:          reality.Dest,
+				PrivateKey:    reality.PrivateKey,
+				MinClientVer:  reality.MinClientVer,
+				MaxClientVer:  reality.MaxClientVer,
+				MaxTimeDiff:   reality.MaxTimeDiff,
+				ProxyProtocol: reality.ProxyProtocol,
+				ShortIds:      reality.ShortIDs,
+				ServerNames:   reality.ServerNames,
+				Fingerprint:   reality.Fingerprint,
+				SpiderX:       reality.SpiderX,
+				PublicKey:     reality.PublicKey,
+			}
+		}
+	}
+
+	return streamSettings
+}
+
+// addProxyProtocol makes the given Xray inbound accept the PROXY protocol, in the settings of its transport,
+// when the node inbound is behind a load balancer such as HAProxy.
+func (w *Writer) addProxyProtocol(in *database.Inbound, inbound *xray.Inbound) {
+	if !in.NetworkSettings.AcceptProxyProtocol {
+		return
+	}
+
+	if inbound.StreamSettings == nil {
+		inbound.StreamSettings = &xray.StreamSettings{Network: "tcp"}
+	}
+	streamSettings := inbound.StreamSettings
+	switch streamSettings.Network {
+	case "tcp":
+		if streamSettings.TcpSettings == nil {
+			streamSettings.TcpSettings = &xray.TcpSettings{}
+		}
+		streamSettings.TcpSettings.AcceptProxyProtocol = true
+	case "ws":
+		if streamSettings.WsSettings == nil {
+			streamSettings.WsSettings = &xray.WebSocketSettings{}
 		}
+		streamSettings.WsSettings.AcceptProxyProtocol = true
+	case "httpupgrade":
+		if streamSettings.HttpUpgradeSettings == nil {
+			streamSettings.HttpUpgradeSettings = &xray.HttpUpgradeSettings{}
+		}
+		streamSettings.HttpUpgradeSettings.AcceptProxyProtocol = true
 	}
 }
 
@@ -324,39 +499,37 @@ func (w *Writer) makeShadowsocksInbound(tag, password, method, network string, p
 }
 
 // Protocol outbound factory - supports all protocols via arch-node package
-func (w *Writer) makeProtocolOutbound(node *database.Node, tag, host, password, method string, port int) (*xray.Outbound, error) {
+func (w *Writer) makeProtocolOutbound(in *database.Inbound, tag, host, password, method string, port int) (*xray.Outbound, error) {
 	xc := xray.NewConfig(w.c.Xray.LogLevel)
 	
-	switch node.Protocol {
+	switch in.Protocol {
 	case "shadowsocks":
 		return xc.MakeShadowsocksOutbound(tag, host, password, method, port), nil
 	case "vless":
 		// For VLESS: tag, address, port, uuid, network
 		network := "tcp" // Default network for outbound
-		if node.NetworkSettings.Transport != "" {
-			network = node.NetworkSettings.Transport
+		if in.NetworkSettings.Transport != "" {
+			network = in.NetworkSettings.Transport
 		}
 		return xc.MakeVlessOutbound(tag, host, port, password, network), nil
 	case "vmess":
 		// Based on test: MakeVmessOutbound("test", "example.com", 443, "uuid", "auto", nil)
 		// Signature: tag, address, port, uuid, encryption, streamSettings
-		streamSettings := w.createStreamSettings(node)
-		return xc.MakeVmessOutbound(tag, host, port, password, node.Encryption, streamSettings), nil
+		streamSettings := w.createStreamSettings(in)
+		return xc.MakeVmessOutbound(tag, host, port, password, in.Encryption, streamSettings), nil
 	case "trojan":
 		// For Trojan: tag, address, port, password, network
 		network := "tcp" // Default network for outbound
-		if node.NetworkSettings.Transport != "" {
-			network = node.NetworkSettings.Transport
+		if in.NetworkSettings.Transport != "" {
+			network = in.NetworkSettings.Transport
 		}
 		return xc.MakeTrojanOutbound(tag, host, port, password, network), nil
 	default:
-		return nil, fmt.Errorf("unsupported protocol: %s", node.Protocol)
+		return nil, fmt.Errorf("unsupported protocol: %s", in.Protocol)
 	}
 }
 
 func (w *Writer) LocalConfig() (*xray.Config, error) {
-	clients := w.clients()
-
 	apiPort, err := utils.FreePort()
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -369,13 +542,13 @@ func (w *Writer) LocalConfig() (*xray.Config, error) {
 	// Only node-specific protocol configurations will be created below.
 
 	// Add routing rules for nodes only
-	if len(w.database.Content.Nodes) > 0 {
+	if len(w.database.Nodes()) > 0 {
 		xc.Routing.Balancers = append(xc.Routing.Balancers, &xray.Balancer{Tag: "relay", Selector: []string{}})
 		xc.Routing.Balancers = append(xc.Routing.Balancers, &xray.Balancer{Tag: "portal", Selector: []string{}})
 	}
 
 	// Configure nodes
-	for _, s := range w.database.Content.Nodes {
+	for _, s := range w.database.Nodes() {
 		var key string // Declare key variable for each node
 		
 		inboundPort, err := utils.FreePort()
@@ -397,44 +570,47 @@ func (w *Writer) LocalConfig() (*xray.Config, error) {
 			nil,
 		))
 
-		// Create client-facing inbound using the node's configured protocol
-		// Use the node's configured listening port and pass actual clients
-		clientPort := s.ListeningPort
-		if clientPort == 0 {
-			// Fallback to random port if not configured
-			clientPort, err = utils.FreePort()
-			if err != nil {
-				return nil, errors.WithStack(err)
-			}
-		}
-		
-		clientInbound, err := w.makeProtocolInbound(
-			s,
-			fmt.Sprintf("client-%d", s.Id),
-			"", // password will be generated inside makeProtocolInbound
-			"tcp",
-			clientPort,
-			clients, // Pass the actual user clients
-		)
-		if err != nil {
-			// Fallback to Shadowsocks if protocol inbound creation fails
-			if key, err = utils.Key32(); err != nil {
-				return nil, err
+		// Create client-facing inbounds using the protocols of the node inbounds
+		// Use the configured listening ports of the inbounds and pass actual clients
+		for _, in := range s.Inbounds {
+			clientPort := in.ListeningPort
+			if clientPort == 0 {
+				// Fallback to random port if not configured
+				clientPort, err = utils.FreePort()
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
 			}
-			clientInbound = xc.MakeShadowsocksInbound(
-				fmt.Sprintf("client-%d", s.Id),
-				key,
-				config.Shadowsocks2022Method, // Use 2022 method for consistency
+
+			clientInbound, err := w.makeProtocolInbound(
+				in,
+				localInboundTag(s.Id, in.Tag),
+				"", // password will be generated inside makeProtocolInbound
 				"tcp",
 				clientPort,
-				clients, // Pass the actual user clients
+				w.clients(in.Protocol, s.Id), // Pass the actual user clients
 			)
+			if err != nil {
+				// Fallback to Shadowsocks if protocol inbound creation fails
+				if key, err = utils.Key32(); err != nil {
+					return nil, err
+				}
+				clientInbound = xc.MakeShadowsocksInbound(
+					localInboundTag(s.Id, in.Tag),
+					key,
+					config.Shadowsocks2022Method, // Use 2022 method for consistency
+					"tcp",
+					clientPort,
+					w.clients("shadowsocks", s.Id), // Pass the actual user clients
+				)
+			}
+			xc.Inbounds = append(xc.Inbounds, clientInbound)
+			xc.Inbounds = append(xc.Inbounds, hopInbounds(in, clientInbound)...)
 		}
-		xc.Inbounds = append(xc.Inbounds, clientInbound)
 
 		xc.Reverse.Portals = append(xc.Reverse.Portals, &xray.ReverseItem{
 			Tag:    fmt.Sprintf("portal-%d", s.Id),
-			Domain: fmt.Sprintf("s%d.reverse.proxy", s.Id),
+			Domain: reverseProxyDomain(s.Id),
 		})
 
 		xc.Routing.Rules = append(xc.Routing.Rules, &xray.Rule{
@@ -477,7 +653,7 @@ func (w *Writer) RemoteConfig(node *database.Node, lastUpdate time.Time, passwor
 
 	xc.Metadata = &xray.Metadata{
 		UpdatedAt: lastUpdate.Format(time.RFC3339),
-		UpdatedBy: w.database.Content.Settings.Host,
+		UpdatedBy: w.database.Settings().Host,
 	}
 
 	// Create relay inbound on node
@@ -500,45 +676,65 @@ func (w *Writer) RemoteConfig(node *database.Node, lastUpdate time.Time, passwor
 		)
 	}
 
+	// The inbounds of the traffic of the clients
+	var clientInboundTags []string
+
 	// Create reverse outbound connection - Always use Shadowsocks for internal communication
 	internalOutbound := w.xray.Config().FindInbound(fmt.Sprintf("internal-%d", node.Id))
 	if internalOutbound != nil {
 		xc.Outbounds = append(xc.Outbounds, xc.MakeShadowsocksOutbound(
 			"internal",
-			w.database.Content.Settings.Host,
+			w.database.Settings().Host,
 			internalOutbound.Settings.Password,
 			internalOutbound.Settings.Method,
 			internalOutbound.Port,
 		))
 		xc.Reverse.Bridges = append(xc.Reverse.Bridges, &xray.ReverseItem{
 			Tag:    "bridge",
-			Domain: fmt.Sprintf("s%d.reverse.proxy", node.Id),
+			Domain: reverseProxyDomain(node.Id),
 		})
 		xc.Routing.Rules = append(
 			xc.Routing.Rules,
 			&xray.Rule{
 				InboundTag:  []string{"bridge"},
-				Domain:      []string{fmt.Sprintf("full:s%d.reverse.proxy", node.Id)},
+				Domain:      []string{"full:" + reverseProxyDomain(node.Id)},
 				OutboundTag: "internal",
 			},
-			&xray.Rule{
-				InboundTag:  []string{"bridge"},
-				OutboundTag: "out",
-			},
 		)
+		clientInboundTags = append(clientInboundTags, "bridge")
 	}
 
-	// Create client-facing inbound using node's configured protocol
-	clientInbound, err := w.makeProtocolInbound(node, "remote", password, "tcp", node.ListeningPort, w.clients())
-	if err == nil && clientInbound != nil {
+	// Create client-facing inbounds using the protocols of the node inbounds
+	for _, in := range node.Inbounds {
+		tag := RemoteInboundTag(node, in)
+		clientInbound, err := w.makeProtocolInbound(in, tag, password, "tcp", in.ListeningPort, w.clients(in.Protocol, 0))
+		if err != nil || clientInbound == nil {
+			continue
+		}
+		if in.ListeningIP != "" {
+			clientInbound.Listen = in.ListeningIP
+		}
+		w.addProxyProtocol(in, clientInbound)
 		xc.Inbounds = append(xc.Inbounds, clientInbound)
-		xc.Routing.Rules = append(
-			xc.Routing.Rules,
-			&xray.Rule{
-				InboundTag:  []string{"remote"},
-				OutboundTag: "out",
-			},
-		)
+		clientInboundTags = append(clientInboundTags, tag)
+		for _, hop := range hopInbounds(in, clientInbound) {
+			xc.Inbounds = append(xc.Inbounds, hop)
+			clientInboundTags = append(clientInboundTags, hop.Tag)
+		}
+	}
+
+	// Apply the DNS and routing settings of the node to the traffic of the clients, which comes through the reverse
+	// proxy (bridge) or directly (the remote inbounds), after the rule of the reverse proxy itself
+	xc.DNS = nodeDNS(node, xc.DNS)
+	if len(clientInboundTags) > 0 {
+		if rules := nodeRules(node, clientInboundTags); len(rules) > 0 {
+			xc.Routing.Rules = append(xc.Routing.Rules, rules...)
+			xc.Outbounds = append(xc.Outbounds, &xray.Outbound{Tag: NodeOutboundBlock, Protocol: "blackhole"})
+		}
+		xc.Routing.Rules = append(xc.Routing.Rules, &xray.Rule{
+			InboundTag:  clientInboundTags,
+			OutboundTag: "out",
+		})
 	}
 
 	return xc
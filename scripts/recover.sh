@@ -16,7 +16,13 @@ systemctl stop "$SERVICE_NAME"
 # Replace database with the last backup
 LAST_BACKUP=$(ls -t "$ROOT/storage/database/backup-"* 2>/dev/null | head -n 1)
 if [ -n "$LAST_BACKUP" ]; then
-  cp "$LAST_BACKUP" "$ROOT/storage/database/app.json"
+  if [[ "$LAST_BACKUP" == *.gz ]]; then
+    gunzip -c "$LAST_BACKUP" > "$ROOT/storage/database/app.json"
+  else
+    cp "$LAST_BACKUP" "$ROOT/storage/database/app.json"
+  fi
+  # The journal holds changes on top of the replaced file, it must not be replayed on the backup.
+  rm -f "$ROOT/storage/database/app.journal"
   echo "$LAST_BACKUP recovered successfully."
 else
     echo "No backup file found."